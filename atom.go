@@ -31,6 +31,11 @@ func (a *Atom) GetVal() sabre.Value {
 	return a.Val
 }
 
+// Deref returns the current value of the atom.
+func (a *Atom) Deref() (sabre.Value, error) {
+	return a.GetVal(), nil
+}
+
 func (a *Atom) String() string {
 	return fmt.Sprintf("(atom %v)", a.GetVal())
 }
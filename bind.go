@@ -3,6 +3,7 @@ package xlisp
 import (
 	"math"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/gdamore/tcell"
 	"github.com/rivo/tview"
@@ -26,7 +27,40 @@ func BindAll(scope sabre.Scope) error {
 		"tview/app-set-input-capture": sabre.ValueOf(AppSetInputCapture(scope)),
 
 		// built-in
-		"core/range": sabre.ValueOf(slangRange),
+		"core/range":       sabre.ValueOf(slangRange),
+		"core/iterate":     sabre.ValueOf(iterate),
+		"core/repeat":      sabre.ValueOf(repeat),
+		"core/cycle":       sabre.ValueOf(cycle),
+		"core/seq":         sabre.ValueOf(seqOf),
+		"core/first":       sabre.ValueOf(seqFirst),
+		"core/next":        sabre.ValueOf(seqNext),
+		"core/rest":        sabre.ValueOf(seqRest),
+		"core/count":       sabre.ValueOf(seqCount),
+		"core/conj":        sabre.ValueOf(conj),
+		"core/apply":       sabre.ValueOf(apply),
+		"core/vector":      sabre.ValueOf(vectorOf),
+		"core/vec":         sabre.ValueOf(toVector),
+		"core/hash-map":    sabre.ValueOf(hashMapOf),
+		"core/hash-set":    sabre.ValueOf(hashSetOf),
+		"core/set":         sabre.ValueOf(toSet),
+		"core/get":         sabre.ValueOf(get),
+		"core/get-in":      sabre.ValueOf(getIn),
+		"core/assoc":       sabre.ValueOf(assoc),
+		"core/dissoc":      sabre.ValueOf(dissoc),
+		"core/keys":        sabre.ValueOf(mapKeys),
+		"core/vals":        sabre.ValueOf(mapVals),
+		"core/contains?":   sabre.ValueOf(containsKey),
+		"core/transient":   sabre.ValueOf(transient),
+		"core/persistent!": sabre.ValueOf(persistent),
+		"core/conj!":       sabre.ValueOf(conjTransient),
+		"core/assoc!":      sabre.ValueOf(assocTransient),
+		"core/dissoc!":     sabre.ValueOf(dissocTransient),
+		"core/disj!":       sabre.ValueOf(disjTransient),
+		"core/lazy-seq": &sabre.Fn{
+			Args:     []string{"body"},
+			Variadic: true,
+			Func:     lazySeq,
+		},
 		"core/future*": &sabre.Fn{
 			Args:     []string{"body"},
 			Variadic: true,
@@ -40,7 +74,7 @@ func BindAll(scope sabre.Scope) error {
 		},
 		"core/bounded?": sabre.ValueOf(bound(scope)),
 		"core/sleep":    sabre.ValueOf(sleep),
-		"core/deref*":   sabre.ValueOf(deref(scope)),
+		"core/deref*":   sabre.ValueOf(deref),
 		"core/doseq": &sabre.Fn{
 			Args:     []string{"vector", "exprs"},
 			Variadic: true,
@@ -75,63 +109,195 @@ func BindAll(scope sabre.Scope) error {
 			Func:     Case,
 			Variadic: true,
 		},
+		"core/let": sabre.SpecialForm{
+			Name:  "let",
+			Parse: parseLet,
+		},
 		"core/loop": sabre.SpecialForm{
 			Name:  "loop",
 			Parse: parseLoop,
 		},
+		"core/try": sabre.SpecialForm{
+			Name:  "try",
+			Parse: parseTry,
+		},
 
 		// special forms
 		"core/do":           sabre.Do,
-		"core/def":          sabre.Def,
+		"core/def":          Def,
+		"core/var":          VarQuote,
+		"core/binding":      Binding,
 		"core/if":           sabre.If,
-		"core/fn*":          sabre.Lambda,
-		"core/macro*":       sabre.Macro,
-		"core/let":          sabre.Let,
+		"core/fn*":          Lambda,
+		"core/macro*":       Macro,
 		"core/quote":        sabre.SimpleQuote,
-		"core/syntax-quote": sabre.SyntaxQuote,
+		"core/syntax-quote": SyntaxQuote,
 		"core/recur":        sabre.Recur,
 
 		"core/macroexpand": sabre.ValueOf(MacroExpand),
-		"core/eval":        sabre.ValueOf(sabre.Eval),
-		"core/eval-string": sabre.ValueOf(sabre.ReadEvalStr),
+		"core/eval":        sabre.ValueOf(Eval),
+		"core/eval-string": sabre.ValueOf(ReadEvalStr),
 		"core/type":        sabre.ValueOf(TypeOf),
 		"core/to-type":     sabre.ValueOf(ToType),
 		"core/impl?":       sabre.ValueOf(Implements),
 		"core/realized*":   sabre.ValueOf(futureRealize),
 		"core/throw":       sabre.ValueOf(Throw),
+		"core/ex-info":     sabre.ValueOf(ExInfo),
+		"core/ex-data":     sabre.ValueOf(ExData),
+		"core/ex-message":  sabre.ValueOf(ExMessage),
+		"core/ex-cause":    sabre.ValueOf(ExCause),
+		"core/with-meta":   sabre.ValueOf(WithMeta),
+		"core/meta":        sabre.ValueOf(Meta),
+		"core/defn*":       sabre.ValueOf(defForm),
 		"core/substring":   sabre.ValueOf(strings.Contains),
 		"core/trim-suffix": sabre.ValueOf(strings.TrimSuffix),
 		"core/resolve":     sabre.ValueOf(resolve(scope)),
 
 		// Type system functions
-		"core/str": sabre.ValueOf(MakeString),
+		"core/str": sabre.ValueOf(str),
 
 		// Math functions
-		"core/+":   sabre.ValueOf(Add),
-		"core/-":   sabre.ValueOf(Sub),
-		"core/*":   sabre.ValueOf(Multiply),
-		"core//":   sabre.ValueOf(Divide),
-		"core/mod": sabre.ValueOf(math.Mod),
-		"core/=":   sabre.ValueOf(sabre.Compare),
-		"core/>":   sabre.ValueOf(Gt),
-		"core/>=":  sabre.ValueOf(GtE),
-		"core/<":   sabre.ValueOf(Lt),
-		"core/<=":  sabre.ValueOf(LtE),
+		"core/+":           sabre.ValueOf(Add),
+		"core/-":           sabre.ValueOf(Sub),
+		"core/*":           sabre.ValueOf(Multiply),
+		"core//":           sabre.ValueOf(Divide),
+		"core/+'":          sabre.ValueOf(AddPromote),
+		"core/-'":          sabre.ValueOf(SubPromote),
+		"core/*'":          sabre.ValueOf(MultiplyPromote),
+		"core/mod":         sabre.ValueOf(mod),
+		"core/==":          sabre.ValueOf(numEquiv),
+		"core/int":         sabre.ValueOf(toInt),
+		"core/float":       sabre.ValueOf(toFloat),
+		"core/bigint":      sabre.ValueOf(toBigInt),
+		"core/bigdec":      sabre.ValueOf(toBigDecimal),
+		"core/numerator":   sabre.ValueOf(numerator),
+		"core/denominator": sabre.ValueOf(denominator),
+		"core/=":           sabre.ValueOf(equals),
+		"core/>":           sabre.ValueOf(Gt),
+		"core/>=":          sabre.ValueOf(GtE),
+		"core/<":           sabre.ValueOf(Lt),
+		"core/<=":          sabre.ValueOf(LtE),
+		"core/compare":     sabre.ValueOf(compare),
+
+		// regular expressions
+		"core/re-pattern": sabre.ValueOf(rePattern),
+		"core/re-find":    sabre.ValueOf(reFind),
+		"core/re-matches": sabre.ValueOf(reMatches),
+		"core/re-seq":     sabre.ValueOf(reSeq),
+		"core/re-groups":  sabre.ValueOf(reGroups),
+
+		// sequence functions
+		"core/sort":         sabre.ValueOf(sortSeq),
+		"core/sort-by":      sabre.ValueOf(sortBy),
+		"core/group-by":     sabre.ValueOf(groupBy),
+		"core/frequencies":  sabre.ValueOf(frequencies),
+		"core/partition":    sabre.ValueOf(partition),
+		"core/partition-by": sabre.ValueOf(partitionBy),
+		"core/distinct":     sabre.ValueOf(distinct),
+		"core/dedupe":       sabre.ValueOf(dedupe),
+		"core/interleave":   sabre.ValueOf(interleave),
+		"core/zipmap":       sabre.ValueOf(zipmap),
+		"core/flatten":      sabre.ValueOf(flatten),
 
 		// io functions
 		"core/$":         sabre.ValueOf(Shell),
 		"core/print":     sabre.ValueOf(Println),
 		"core/printf":    sabre.ValueOf(Printf),
+		"core/eprint":    sabre.ValueOf(Eprintln),
+		"core/eprintf":   sabre.ValueOf(Eprintf),
 		"core/read*":     sabre.ValueOf(Read),
 		"core/random":    sabre.ValueOf(Random),
 		"core/shuffle":   sabre.ValueOf(Shuffle),
 		"core/read-file": sabre.ValueOf(ReadFile),
+		"core/load-file": sabre.ValueOf(LoadFile),
+		"core/with-out-str": &sabre.Fn{
+			Args:     []string{"body"},
+			Variadic: true,
+			Func:     withOutStr,
+		},
+		"core/with-in-str": &sabre.Fn{
+			Args:     []string{"s", "body"},
+			Variadic: true,
+			Func:     withInStr,
+		},
+
+		// math
+		"math/abs":                      sabre.ValueOf(numAbs),
+		"math/min":                      sabre.ValueOf(numMin),
+		"math/max":                      sabre.ValueOf(numMax),
+		"math/pow":                      sabre.ValueOf(numPow),
+		"math/sqrt":                     sabre.ValueOf(floatFn(math.Sqrt)),
+		"math/cbrt":                     sabre.ValueOf(floatFn(math.Cbrt)),
+		"math/floor":                    sabre.ValueOf(roundFn(roundFloor)),
+		"math/ceil":                     sabre.ValueOf(roundFn(roundCeil)),
+		"math/round":                    sabre.ValueOf(roundFn(roundHalfUp)),
+		"math/sin":                      sabre.ValueOf(floatFn(math.Sin)),
+		"math/cos":                      sabre.ValueOf(floatFn(math.Cos)),
+		"math/tan":                      sabre.ValueOf(floatFn(math.Tan)),
+		"math/asin":                     sabre.ValueOf(floatFn(math.Asin)),
+		"math/acos":                     sabre.ValueOf(floatFn(math.Acos)),
+		"math/atan":                     sabre.ValueOf(floatFn(math.Atan)),
+		"math/atan2":                    sabre.ValueOf(numAtan2),
+		"math/sinh":                     sabre.ValueOf(floatFn(math.Sinh)),
+		"math/cosh":                     sabre.ValueOf(floatFn(math.Cosh)),
+		"math/tanh":                     sabre.ValueOf(floatFn(math.Tanh)),
+		"math/exp":                      sabre.ValueOf(floatFn(math.Exp)),
+		"math/log":                      sabre.ValueOf(floatFn(math.Log)),
+		"math/log10":                    sabre.ValueOf(floatFn(math.Log10)),
+		"math/log2":                     sabre.ValueOf(floatFn(math.Log2)),
+		"math/quot":                     sabre.ValueOf(quot),
+		"math/rem":                      sabre.ValueOf(rem),
+		"math/mod":                      sabre.ValueOf(mod),
+		"math/bit-and":                  sabre.ValueOf(bitFn(bitAnd)),
+		"math/bit-or":                   sabre.ValueOf(bitFn(bitOr)),
+		"math/bit-xor":                  sabre.ValueOf(bitFn(bitXor)),
+		"math/bit-not":                  sabre.ValueOf(bitNot),
+		"math/bit-shift-left":           sabre.ValueOf(bitShiftLeft),
+		"math/bit-shift-right":          sabre.ValueOf(bitShiftRight),
+		"math/unsigned-bit-shift-right": sabre.ValueOf(unsignedBitShiftRight),
+		"math/NaN?":                     sabre.ValueOf(isNaNValue),
+		"math/infinite?":                sabre.ValueOf(isInfinite),
+		"math/PI":                       sabre.Float64(math.Pi),
+		"math/E":                        sabre.Float64(math.E),
+		"math/NaN":                      sabre.Float64(math.NaN()),
+		"math/INF":                      sabre.Float64(math.Inf(1)),
+		"math/-INF":                     sabre.Float64(math.Inf(-1)),
+		"math/MAX-INT":                  sabre.Int64(math.MaxInt64),
+		"math/MIN-INT":                  sabre.Int64(math.MinInt64),
 
 		// strings
-		"string/split": sabre.ValueOf(splitString),
+		"string/split":         sabre.ValueOf(splitString),
+		"string/split-lines":   sabre.ValueOf(splitLines),
+		"string/join":          sabre.ValueOf(joinStrings),
+		"string/upper-case":    sabre.ValueOf(strings.ToUpper),
+		"string/lower-case":    sabre.ValueOf(strings.ToLower),
+		"string/capitalize":    sabre.ValueOf(capitalize),
+		"string/trim":          sabre.ValueOf(strings.TrimSpace),
+		"string/triml":         sabre.ValueOf(trimLeft),
+		"string/trimr":         sabre.ValueOf(trimRight),
+		"string/replace":       sabre.ValueOf(replaceString),
+		"string/includes?":     sabre.ValueOf(strings.Contains),
+		"string/starts-with?":  sabre.ValueOf(strings.HasPrefix),
+		"string/ends-with?":    sabre.ValueOf(strings.HasSuffix),
+		"string/index-of":      sabre.ValueOf(indexOf),
+		"string/last-index-of": sabre.ValueOf(lastIndexOf),
+		"string/subs":          sabre.ValueOf(subs),
+		"string/blank?":        sabre.ValueOf(isBlank),
+		"string/pad":           sabre.ValueOf(padRight),
+		"string/pad-left":      sabre.ValueOf(padLeft),
+		"string/repeat":        sabre.ValueOf(repeatString),
+		"string/reverse":       sabre.ValueOf(reverseString),
+		"string/length":        sabre.ValueOf(utf8.RuneCountInString),
 
-		"types/Seq":       TypeOf((*sabre.Seq)(nil)),
-		"types/Invokable": TypeOf((*sabre.Invokable)(nil)),
+		"types/Seq":        TypeOf((*sabre.Seq)(nil)),
+		"types/Exception":  TypeOf(&Exception{}),
+		"types/Future":     TypeOf(&Future{}),
+		"types/LazySeq":    TypeOf(&LazySeq{}),
+		"types/InStream":   TypeOf(&InStream{}),
+		"types/OutStream":  TypeOf(&OutStream{}),
+		"types/Invokable":  TypeOf((*sabre.Invokable)(nil)),
+		"types/Comparable": TypeOf((*Comparable)(nil)),
+		"types/Regex":      TypeOf(&Regex{}),
 	}
 
 	for sym, val := range core {
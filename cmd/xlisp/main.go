@@ -1,18 +1,13 @@
 package main
 
 import (
-	"context"
-	"errors"
 	"flag"
 	"fmt"
 	"os"
-	"path/filepath"
 	"runtime"
 
 	"github.com/chzyer/readline"
 	"github.com/issadarkthing/xlisp"
-	"github.com/spy16/sabre"
-	"github.com/spy16/sabre/repl"
 )
 
 const help = `Xlisp %s [Commit: %s] [Compiled with %s]
@@ -24,6 +19,7 @@ var (
 
 	executeStr   = flag.String("e", "", "Execute string")
 	unload       = flag.Bool("u", false, "Unload core library")
+	corePath     = flag.String("core", os.Getenv("XLISP_CORE"), "Load core library from file instead of the embedded one (env XLISP_CORE)")
 	printVersion = flag.Bool("v", false, "Prints slang version and exit")
 )
 
@@ -35,35 +31,42 @@ func main() {
 		return
 	}
 
-	xl := xlisp.New()
-	xl.BindGo("*version*", version)
+	opts := []xlisp.Option{
+		xlisp.WithBindings(map[string]interface{}{"*version*": version}),
+	}
 
-	var result sabre.Value
-	var err error
+	switch {
+	case *unload:
+		opts = append(opts, xlisp.WithoutCoreLibrary())
 
-	resolvedPath, err := filepath.Abs("/etc/xlisp/core.xlisp")
-	if err != nil {
-		fatalf("error: %v\n", err)
-	}
+	case *corePath != "":
+		core, err := os.Open(*corePath)
+		if err != nil {
+			fatalf("error: %v\n", err)
+		}
+		defer core.Close()
 
-	core, err := os.Open(resolvedPath)
-	if err != nil {
-		fatalf("error: %v\n", err)
+		opts = append(opts, xlisp.WithCoreLibrary(core))
 	}
-	defer core.Close()
 
-	if !*unload {
-		_, err = xl.ReadEval(core)
-	}
+	interactive := flag.NArg() == 0 && *executeStr == ""
 
-	xl.SwitchNS(sabre.Symbol{Value: "user"})
+	var lr *readline.Instance
+	if interactive {
+		var err error
+		lr, err = readline.New("")
+		if err != nil {
+			fatalf("readline: %v\n", err)
+		}
+		defer lr.Close()
 
-	if len(os.Args) > 1 {
+		opts = append(opts, xlisp.WithStderr(lr.Stderr()))
+	}
 
-		var file int
-		file = flag.NFlag() + 1
+	xl := xlisp.New(opts...)
 
-		fh, err := os.Open(os.Args[file])
+	if flag.NArg() > 0 {
+		fh, err := os.Open(flag.Arg(0))
 		if err != nil {
 			fatalf("error: %v\n", err)
 		}
@@ -72,53 +75,46 @@ func main() {
 		xl.BindGo("*file*", fh.Name())
 		_, err = xl.ReadEval(fh)
 		if err != nil {
-			fatalf("error: %v\n", err)
+			fatal(xl, err)
 		}
 		return
 	}
 
 	if *executeStr != "" {
-		result, err = xl.ReadEvalStr(*executeStr)
-		fmt.Println(result)
+		result, err := xl.ReadEvalStr(*executeStr)
+		if err == nil {
+			err = xlisp.RealizeAll(result)
+		}
 		if err != nil {
-			fatalf("error: %v\n", err)
+			fatal(xl, err)
 		}
+		fmt.Println(result)
 		return
 	}
 
-	lr, errMapper := readlineInstance()
-
-	repl := repl.New(xl,
-		repl.WithBanner(fmt.Sprintf(help, version, commit, runtime.Version())),
-		repl.WithInput(lr, errMapper),
-		repl.WithOutput(lr.Stdout()),
-		repl.WithPrompts("=>", "|"),
-	)
+	repl := &repl{
+		xl:          xl,
+		lr:          lr,
+		prompt:      "=>",
+		multiPrompt: "|",
+	}
 
-	if err := repl.Loop(context.Background()); err != nil {
-		fatalf("REPL exited with error: %v", err)
+	if err := repl.Loop(fmt.Sprintf(help, version, commit, runtime.Version())); err != nil {
+		fatal(xl, fmt.Errorf("REPL exited with error: %w", err))
 	}
 	fmt.Println("Bye!")
 }
 
-func readlineInstance() (*readline.Instance, func(error) error) {
-	lr, err := readline.New("")
-	if err != nil {
-		fatalf("readline: %v", err)
+// fatal writes the uncaught error to the stream bound to *err* and exits.
+func fatal(xl *xlisp.Xlisp, err error) {
+	if xlisp.Eprintf(xl, "error: %v\n", err) != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
 	}
-
-	errMapper := func(e error) error {
-		if errors.Is(e, readline.ErrInterrupt) {
-			return nil
-		}
-
-		return e
-	}
-
-	return lr, errMapper
+	os.Exit(1)
 }
 
+// fatalf is used for errors before the interpreter is created.
 func fatalf(format string, args ...interface{}) {
-	fmt.Printf(format, args...)
+	fmt.Fprintf(os.Stderr, format, args...)
 	os.Exit(1)
 }
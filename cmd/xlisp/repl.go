@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/chzyer/readline"
+	"github.com/issadarkthing/xlisp"
+	"github.com/spy16/sabre"
+)
+
+// repl implements the read-eval-print loop. Unlike the sabre REPL, each
+// form is evaluated with its own context so that an interrupt (Ctrl-C)
+// stops the running evaluation instead of being ignored.
+type repl struct {
+	xl          *xlisp.Xlisp
+	lr          *readline.Instance
+	prompt      string
+	multiPrompt string
+}
+
+// Loop runs the REPL until the input is exhausted.
+func (r *repl) Loop(banner string) error {
+	fmt.Println(banner)
+
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+
+	for {
+		form, err := r.read()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+
+			var readErr sabre.ReadError
+			if !errors.As(err, &readErr) {
+				return err
+			}
+
+			r.print(err)
+			continue
+		}
+
+		if form == nil {
+			continue
+		}
+
+		v, err := r.eval(form, interrupts)
+		if err != nil {
+			r.print(err)
+			continue
+		}
+
+		r.print(v)
+	}
+}
+
+// eval evaluates the form until it completes or an interrupt is received.
+func (r *repl) eval(form sabre.Value, interrupts <-chan os.Signal) (sabre.Value, error) {
+	drain(interrupts)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-interrupts:
+			cancel()
+		case <-done:
+		}
+	}()
+
+	v, err := r.xl.EvalContext(ctx, form)
+	if err != nil {
+		return nil, err
+	}
+
+	return v, xlisp.RealizeAll(v)
+}
+
+// read reads lines until they make up complete forms.
+func (r *repl) read() (sabre.Value, error) {
+	var src string
+	lineNo := 1
+
+	for {
+		r.setPrompt(lineNo > 1)
+
+		line, err := r.lr.Readline()
+		if errors.Is(err, readline.ErrInterrupt) {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		src += line + "\n"
+
+		if strings.TrimSpace(src) == "" {
+			return nil, nil
+		}
+
+		rd := xlisp.NewReader(strings.NewReader(src))
+		rd.File = "REPL"
+
+		form, err := rd.All()
+		if err != nil {
+			if errors.Is(err, sabre.ErrEOF) {
+				lineNo++
+				continue
+			}
+
+			return nil, err
+		}
+
+		return form, nil
+	}
+}
+
+// print writes values to the standard output and errors to the stream
+// bound to *err*.
+func (r *repl) print(v interface{}) {
+	switch v.(type) {
+	case error:
+		if err := xlisp.Eprintf(r.xl, "%+v\n", v); err != nil {
+			fmt.Fprintf(r.lr.Stderr(), "%+v\n", v)
+		}
+
+	default:
+		fmt.Fprintf(r.lr.Stdout(), "%s\n", v)
+	}
+}
+
+func (r *repl) setPrompt(multiline bool) {
+	nsPrefix := r.xl.CurrentNS()
+	prompt := r.prompt
+
+	if multiline {
+		nsPrefix = strings.Repeat(" ", len(nsPrefix)+1)
+		prompt = r.multiPrompt
+	}
+
+	r.lr.SetPrompt(fmt.Sprintf("%s%s ", nsPrefix, prompt))
+}
+
+// drain discards interrupts received while no evaluation was running.
+func drain(interrupts <-chan os.Signal) {
+	for {
+		select {
+		case <-interrupts:
+		default:
+			return
+		}
+	}
+}
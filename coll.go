@@ -0,0 +1,116 @@
+package xlisp
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/spy16/sabre"
+)
+
+// conj implements (conj coll & vals). Values are appended to vectors and
+// lists, added to sets and merged into maps as [key value] entries.
+func conj(coll sabre.Value, vals ...sabre.Value) (sabre.Value, error) {
+	switch c := coll.(type) {
+	case sabre.Nil:
+		return (&sabre.List{}).Conj(vals...), nil
+
+	case *HashMap:
+		for _, v := range vals {
+			if !isMapEntry(v) {
+				return nil, fmt.Errorf("cannot conj %s onto a hash-map, "+
+					"expecting a [key value] vector or a hash-map", v)
+			}
+		}
+		return c.Conj(vals...), nil
+
+	case sabre.Seq:
+		return c.Conj(vals...), nil
+	}
+
+	return nil, fmt.Errorf("argument must be a collection, not %s",
+		reflect.TypeOf(coll))
+}
+
+func isMapEntry(v sabre.Value) bool {
+	switch entry := v.(type) {
+	case *Vector:
+		return entry.Size() == 2
+	case *HashMap:
+		return true
+	}
+	return false
+}
+
+// apply implements (apply f args* coll) by invoking f with the arguments
+// followed by the values of the collection.
+func apply(scope sabre.Scope, f sabre.Invokable, args ...sabre.Value) (sabre.Value, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("apply requires a collection of arguments")
+	}
+
+	vals := append([]sabre.Value(nil), args[:len(args)-1]...)
+	err := forEach(args[len(args)-1], func(v sabre.Value) error {
+		vals = append(vals, v)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return invoke(scope, f, vals...)
+}
+
+// vectorOf implements (vector & vals).
+func vectorOf(vals ...sabre.Value) *Vector {
+	return NewVector(vals...)
+}
+
+// toVector implements (vec coll).
+func toVector(coll sabre.Value) (*Vector, error) {
+	if vec, isVector := coll.(*Vector); isVector {
+		return vec, nil
+	}
+
+	t := NewVector().Transient()
+	err := forEach(coll, func(v sabre.Value) error {
+		t.conj(v)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return t.persistent(), nil
+}
+
+// hashMapOf implements (hash-map & kvs).
+func hashMapOf(kvs ...sabre.Value) (*HashMap, error) {
+	if len(kvs)%2 != 0 {
+		return nil, fmt.Errorf("hash-map requires an even number of arguments, got %d",
+			len(kvs))
+	}
+
+	return NewHashMap(kvs...), nil
+}
+
+// hashSetOf implements (hash-set & vals).
+func hashSetOf(vals ...sabre.Value) *Set {
+	return NewSet(vals...)
+}
+
+// toSet implements (set coll).
+func toSet(coll sabre.Value) (*Set, error) {
+	if set, isSet := coll.(*Set); isSet {
+		return set, nil
+	}
+
+	t := NewSet().Transient()
+	err := forEach(coll, func(v sabre.Value) error {
+		return t.Conj(v)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return t.Persistent()
+}
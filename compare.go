@@ -0,0 +1,156 @@
+package xlisp
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/spy16/sabre"
+)
+
+// Comparable is implemented by values with a natural ordering. CompareTo
+// returns a negative number, zero or a positive number if the value is
+// less than, equal to or greater than the other value, and an error if the
+// values cannot be compared. Go values implementing Comparable can be
+// compared and sorted by scripts.
+type Comparable interface {
+	CompareTo(other sabre.Value) (int, error)
+}
+
+// compare implements (compare a b) which returns -1, 0 or 1 if a is less
+// than, equal to or greater than b. Nil is less than any other value,
+// numbers are compared by value regardless of their types and strings,
+// symbols and keywords lexicographically.
+func compare(a, b sabre.Value) (int, error) {
+	c, err := compareValues(a, b)
+	switch {
+	case err != nil:
+		return 0, err
+	case c < 0:
+		return -1, nil
+	case c > 0:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func compareValues(a, b sabre.Value) (int, error) {
+	aNil, bNil := isNil(a), isNil(b)
+	switch {
+	case aNil && bNil:
+		return 0, nil
+	case aNil:
+		return -1, nil
+	case bNil:
+		return 1, nil
+	}
+
+	if err := headError(a, b); err != nil {
+		return 0, err
+	}
+
+	if _, err := kindOf(a); err == nil {
+		if _, err := kindOf(b); err == nil {
+			return numCompare(a, b)
+		}
+		return 0, notComparable(a, b)
+	}
+
+	switch x := a.(type) {
+	case sabre.String:
+		if y, ok := b.(sabre.String); ok {
+			return strings.Compare(string(x), string(y)), nil
+		}
+
+	case sabre.Character:
+		if y, ok := b.(sabre.Character); ok {
+			return int(x) - int(y), nil
+		}
+
+	case sabre.Symbol:
+		if y, ok := b.(sabre.Symbol); ok {
+			return strings.Compare(x.Value, y.Value), nil
+		}
+
+	case sabre.Bool:
+		if y, ok := b.(sabre.Bool); ok {
+			return boolRank(x) - boolRank(y), nil
+		}
+
+	case Comparable:
+		return x.CompareTo(b)
+	}
+
+	return 0, notComparable(a, b)
+}
+
+func isNil(v sabre.Value) bool {
+	return v == nil || v == (sabre.Nil{})
+}
+
+func boolRank(b sabre.Bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// headError returns the error raised while realizing the first value of
+// either lazy sequence. Sequences cannot be compared but such an error
+// better explains the failure.
+func headError(vals ...sabre.Value) error {
+	for _, v := range vals {
+		if isLazy(v) {
+			if _, err := toSeq(v); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func notComparable(a, b sabre.Value) error {
+	return fmt.Errorf("cannot compare %s with %s", reflect.TypeOf(a), reflect.TypeOf(b))
+}
+
+// Lt returns true if the given args are monotonically increasing.
+func Lt(x sabre.Value, args ...sabre.Value) (bool, error) {
+	return monotonic(func(c int) bool { return c < 0 }, x, args)
+}
+
+// LtE returns true if the given args are monotonically non-decreasing.
+func LtE(x sabre.Value, args ...sabre.Value) (bool, error) {
+	return monotonic(func(c int) bool { return c <= 0 }, x, args)
+}
+
+// Gt returns true if the given args are monotonically decreasing.
+func Gt(x sabre.Value, args ...sabre.Value) (bool, error) {
+	return monotonic(func(c int) bool { return c > 0 }, x, args)
+}
+
+// GtE returns true if the given args are monotonically non-increasing.
+func GtE(x sabre.Value, args ...sabre.Value) (bool, error) {
+	return monotonic(func(c int) bool { return c >= 0 }, x, args)
+}
+
+// monotonic returns true if ok holds for the comparison of each pair of
+// adjacent args. All the args are checked to be comparable even if the
+// result is known early. Comparisons involving NaN are false.
+func monotonic(ok func(int) bool, x sabre.Value, args []sabre.Value) (bool, error) {
+	res := true
+	prev := x
+	for _, arg := range args {
+		c, err := compareValues(prev, arg)
+		if err != nil {
+			return false, err
+		}
+
+		if isNaN(prev) || isNaN(arg) || !ok(c) {
+			res = false
+		}
+		prev = arg
+	}
+
+	return res, nil
+}
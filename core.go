@@ -1,7 +1,6 @@
 package xlisp
 
 import (
-	"errors"
 	"fmt"
 	"reflect"
 	"strings"
@@ -34,7 +33,7 @@ func Case(scope sabre.Scope, args []sabre.Value) (sabre.Value, error) {
 			return val, nil
 		}
 
-		if sabre.Compare(res, val) {
+		if equal(res, val) {
 			return sabre.Eval(scope, args[start+1])
 		}
 	}
@@ -49,10 +48,39 @@ func MacroExpand(scope sabre.Scope, f sabre.Value) (sabre.Value, error) {
 	return f, err
 }
 
-// Throw converts args to strings and returns an error with all the strings
-// joined.
+// Eval evaluates the form in the given scope. Forms accessing members of
+// Go values which are not permitted are rejected.
+func Eval(scope sabre.Scope, form sabre.Value) (sabre.Value, error) {
+	if err := checkForm(scope, form); err != nil {
+		return nil, err
+	}
+
+	return sabre.Eval(scope, form)
+}
+
+// ReadEvalStr reads all the forms in the source and evaluates them in the
+// given scope using Eval.
+func ReadEvalStr(scope sabre.Scope, src string) (sabre.Value, error) {
+	mod, err := NewReader(strings.NewReader(src)).All()
+	if err != nil {
+		return nil, err
+	}
+
+	return Eval(scope, mod)
+}
+
+// Throw returns the exception if a single exception value is given. Otherwise
+// converts args to strings and returns an exception with all the strings
+// joined as its message.
 func Throw(scope sabre.Scope, args ...sabre.Value) error {
-	return errors.New(strings.Trim(MakeString(args...).String(), "\""))
+	if len(args) == 1 {
+		if exc, ok := args[0].(*Exception); ok {
+			return exc
+		}
+	}
+
+	msg := strings.Trim(MakeString(args...).String(), "\"")
+	return &Exception{Message: msg}
 }
 
 // Realize realizes a sequence by continuously calling First() and Next()
@@ -127,17 +155,42 @@ func MakeString(vals ...sabre.Value) sabre.Value {
 			return sabre.String("")
 		}
 
-		return sabre.String(strings.Trim(vals[0].String(), "\""))
+		return sabre.String(stringOf(vals[0]))
 
 	default:
 		var sb strings.Builder
 		for _, v := range vals {
-			sb.WriteString(strings.Trim(v.String(), "\""))
+			sb.WriteString(stringOf(v))
 		}
 		return sabre.String(sb.String())
 	}
 }
 
+// str implements (str & xs) using MakeString. The lazy sequences in the
+// values are realized first so that the errors raised while realizing
+// them are returned.
+func str(vals ...sabre.Value) (sabre.Value, error) {
+	if err := realizeValues(vals); err != nil {
+		return nil, err
+	}
+
+	return MakeString(vals...), nil
+}
+
+// stringOf returns the value as str shows it, i.e. strings without quotes
+// and regexes as their pattern.
+func stringOf(v sabre.Value) string {
+	switch s := v.(type) {
+	case sabre.String:
+		return string(s)
+
+	case *Regex:
+		return s.Regexp.String()
+	}
+
+	return v.String()
+}
+
 func threadCall(scope sabre.Scope, args []sabre.Value, last bool) (sabre.Value, error) {
 
 	err := checkArityAtLeast(1, len(args))
@@ -197,64 +250,43 @@ func isTruthy(v sabre.Value) bool {
 	return true
 }
 
-func slangRange(args ...int) (Any, error) {
-	var result []sabre.Value
-
-	switch len(args) {
-	case 1:
-		result = createRange(0, args[0], 1)
-	case 2:
-		result = createRange(args[0], args[1], 1)
-	case 3:
-		result = createRange(args[0], args[1], args[2])
-	}
-
-	return &sabre.List{Values: result}, nil
-}
-
-func createRange(min, max, step int) []sabre.Value {
-
-	result := make([]sabre.Value, 0, max-min)
-	for i := min; i < max; i += step {
-		result = append(result, sabre.Int64(i))
-	}
-	return result
-}
-
 func doSeq(scope sabre.Scope, args []sabre.Value) (sabre.Value, error) {
 
 	arg1 := args[0]
-	vecs, ok := arg1.(sabre.Vector)
-	if !ok {
+	vecs, ok := formValues(arg1)
+	if !ok || len(vecs) != 2 {
 		return nil, fmt.Errorf("Invalid type")
 	}
 
-	coll, err := vecs.Values[1].Eval(scope)
+	coll, err := vecs[1].Eval(scope)
 	if err != nil {
 		return nil, err
 	}
 
-	l, ok := coll.(sabre.Seq)
-	if !ok {
-		return nil, fmt.Errorf("Invalid type")
+	if err := checkPattern(vecs[0]); err != nil {
+		return nil, err
 	}
 
-	list := Realize(l)
+	var result sabre.Value
+	err = forEach(coll, func(v sabre.Value) error {
+		if err := checkEval(scope); err != nil {
+			return err
+		}
 
-	symbol, ok := vecs.Values[0].(sabre.Symbol)
-	if !ok {
-		return nil, fmt.Errorf("invalid type; expected symbol")
-	}
+		itemScope := newLocalScope(scope)
+		if err := destructure(itemScope, vecs[0], v); err != nil {
+			return err
+		}
 
-	var result sabre.Value
-	for _, v := range list.Values {
-		scope.Bind(symbol.Value, v)
 		for _, body := range args[1:] {
-			result, err = body.Eval(scope)
-			if err != nil {
-				return nil, err
+			if result, err = body.Eval(itemScope); err != nil {
+				return err
 			}
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return result, nil
@@ -278,7 +310,9 @@ func swap(scope sabre.Scope, args []sabre.Value) (sabre.Value, error) {
 		return nil, err
 	}
 
-	scope.Bind(symbol.Value, value)
+	if !assign(scope, symbol.Value, value) {
+		_ = scope.Bind(symbol.Value, value)
+	}
 	return value, nil
 }
 
@@ -304,61 +338,103 @@ func stringTypeOf(v interface{}) string {
 	return reflect.TypeOf(v).String()
 }
 
-// Evaluate the expressions in another goroutine; returns chan
-func future(scope sabre.Scope, args []sabre.Value) (sabre.Value, error) {
+// Future is the result of an expression being evaluated in another
+// goroutine.
+type Future struct {
+	done  chan struct{}
+	value sabre.Value
+	err   error
+}
 
-	ch := make(chan sabre.Value)
+// Eval returns the future itself.
+func (f *Future) Eval(_ sabre.Scope) (sabre.Value, error) {
+	return f, nil
+}
 
-	go func() {
+func (f *Future) String() string {
+	if !f.Realized() {
+		return "#future[pending]"
+	}
 
-		val, err := args[0].Eval(scope)
-		if err != nil {
-			panic(err)
-		}
+	if f.err != nil {
+		return fmt.Sprintf("#future[failed: %v]", f.err)
+	}
 
-		ch <- val
-		close(ch)
-	}()
+	return fmt.Sprintf("#future[%v]", f.value)
+}
 
-	return sabre.ValueOf(ch), nil
+// Deref blocks until the future is resolved and returns its result. The
+// error raised by the expression, if any, is returned instead.
+func (f *Future) Deref() (sabre.Value, error) {
+	<-f.done
+	return f.value, f.err
 }
 
-// Deref chan from future to get the value. This call is blocking until future is resolved.
-// The result will be cached.
-func deref(scope sabre.Scope) func(sabre.Symbol, <-chan sabre.Value) (sabre.Value, error) {
+// Realized returns true if the future has been resolved.
+func (f *Future) Realized() bool {
+	select {
+	case <-f.done:
+		return true
+	default:
+		return false
+	}
+}
 
-	return func(symbol sabre.Symbol, ch <-chan sabre.Value) (sabre.Value, error) {
+// Evaluate the expression in another goroutine. The goroutine is part of the
+// evaluation that started it and is stopped when that evaluation is
+// cancelled.
+func future(scope sabre.Scope, args []sabre.Value) (sabre.Value, error) {
+	f := &Future{done: make(chan struct{})}
 
-		derefSymbol := fmt.Sprintf("__deref__%s__result__", symbol.Value)
+	go func() {
+		defer close(f.done)
+		f.value, f.err = args[0].Eval(scope)
+	}()
 
-		value, ok := <-ch
-		if ok {
-			scope.Bind(derefSymbol, value)
-			return value, nil
-		}
+	return f, nil
+}
 
-		value, err := scope.Resolve(derefSymbol)
-		if err != nil {
-			return nil, err
-		}
+// Derefable is implemented by reference types holding a value, such as
+// futures, atoms and vars, which can be read with deref or @.
+type Derefable interface {
+	Deref() (sabre.Value, error)
+}
 
-		return value, nil
+// Deref the reference to get its value. Dereferencing a future blocks until
+// the future is resolved.
+func deref(v sabre.Value) (sabre.Value, error) {
+	ref, ok := v.(Derefable)
+	if !ok {
+		return nil, fmt.Errorf("cannot deref value of type %s", reflect.TypeOf(v))
 	}
-}
 
-func sleep(s int) {
-	time.Sleep(time.Millisecond * time.Duration(s))
+	return ref.Deref()
 }
 
-func futureRealize(ch <-chan sabre.Value) bool {
+// sleep pauses for the given number of milliseconds or until the evaluation
+// is cancelled.
+func sleep(scope sabre.Scope, ms int) error {
+	timer := time.NewTimer(time.Millisecond * time.Duration(ms))
+	defer timer.Stop()
+
+	st := stateOf(scope)
+	if st == nil {
+		<-timer.C
+		return nil
+	}
+
 	select {
-	case _, ok := <-ch:
-		return !ok
-	default:
-		return false
+	case <-timer.C:
+		return nil
+	case <-st.ctx.Done():
+		return st.ctx.Err()
 	}
 }
 
+func futureRealize(f *Future) bool {
+	return f.Realized()
+}
+
 func xlispTime(scope sabre.Scope, args []sabre.Value) (sabre.Value, error) {
 
 	var lastVal sabre.Value
@@ -371,53 +447,42 @@ func xlispTime(scope sabre.Scope, args []sabre.Value) (sabre.Value, error) {
 		}
 	}
 	final := time.Since(initial)
-	fmt.Printf("Elapsed time: %s\n", final.String())
+	if err := Printf(scope, "Elapsed time: %s\n", final.String()); err != nil {
+		return nil, err
+	}
 
 	return lastVal, nil
 }
 
-func parseLoop(scope sabre.Scope, args []sabre.Value) (*sabre.Fn, error) {
-	if len(args) < 1 {
-		return nil, fmt.Errorf("call requires at-least bindings argument")
-	}
-
-	vec, isVector := args[0].(sabre.Vector)
-	if !isVector {
-		return nil, fmt.Errorf(
-			"first argument to let must be bindings vector, not %v",
-			reflect.TypeOf(args[0]),
-		)
+func parseLet(scope sabre.Scope, args []sabre.Value) (*sabre.Fn, error) {
+	bindings, err := parseBindings(args)
+	if err != nil {
+		return nil, err
 	}
 
-	if len(vec.Values)%2 != 0 {
-		return nil, fmt.Errorf("bindings must contain event forms")
-	}
+	return &sabre.Fn{
+		Func: func(scope sabre.Scope, _ []sabre.Value) (sabre.Value, error) {
+			letScope, err := bindAll(scope, bindings)
+			if err != nil {
+				return nil, err
+			}
 
-	var bindings []binding
-	for i := 0; i < len(vec.Values); i += 2 {
-		sym, isSymbol := vec.Values[i].(sabre.Symbol)
-		if !isSymbol {
-			return nil, fmt.Errorf(
-				"item at %d must be symbol, not %s",
-				i, vec.Values[i],
-			)
-		}
+			return sabre.Module(args[1:]).Eval(letScope)
+		},
+	}, nil
+}
 
-		bindings = append(bindings, binding{
-			Name: sym.Value,
-			Expr: vec.Values[i+1],
-		})
+func parseLoop(scope sabre.Scope, args []sabre.Value) (*sabre.Fn, error) {
+	bindings, err := parseBindings(args)
+	if err != nil {
+		return nil, err
 	}
 
 	return &sabre.Fn{
 		Func: func(scope sabre.Scope, _ []sabre.Value) (sabre.Value, error) {
-			letScope := sabre.NewScope(scope)
-			for _, b := range bindings {
-				v, err := b.Expr.Eval(letScope)
-				if err != nil {
-					return nil, err
-				}
-				_ = letScope.Bind(b.Name, v)
+			letScope, err := bindAll(scope, bindings)
+			if err != nil {
+				return nil, err
 			}
 
 			result, err := sabre.Module(args[1:]).Eval(letScope)
@@ -426,10 +491,20 @@ func parseLoop(scope sabre.Scope, args []sabre.Value) (*sabre.Fn, error) {
 			}
 
 			for isRecur(result) {
+				if err := letScope.check(); err != nil {
+					return nil, err
+				}
 
 				newBindings := result.(*sabre.List).Values[1:]
+				if len(newBindings) != len(bindings) {
+					return nil, fmt.Errorf("recur expects %d argument(s), got %d",
+						len(bindings), len(newBindings))
+				}
+
 				for i, b := range bindings {
-					letScope.Bind(b.Name, newBindings[i])
+					if err := destructure(letScope, b.Target, newBindings[i]); err != nil {
+						return nil, err
+					}
 				}
 
 				result, err = sabre.Module(args[1:]).Eval(letScope)
@@ -443,6 +518,56 @@ func parseLoop(scope sabre.Scope, args []sabre.Value) (*sabre.Fn, error) {
 	}, nil
 }
 
+// parseBindings parses the bindings vector of let and loop forms.
+func parseBindings(args []sabre.Value) ([]binding, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("call requires at-least bindings argument")
+	}
+
+	vec, isVector := formValues(args[0])
+	if !isVector {
+		return nil, fmt.Errorf(
+			"first argument to let must be bindings vector, not %v",
+			reflect.TypeOf(args[0]),
+		)
+	}
+
+	if len(vec)%2 != 0 {
+		return nil, fmt.Errorf("bindings must contain event forms")
+	}
+
+	var bindings []binding
+	for i := 0; i < len(vec); i += 2 {
+		if err := checkPattern(vec[i]); err != nil {
+			return nil, fmt.Errorf("item at %d: %v", i, err)
+		}
+
+		bindings = append(bindings, binding{
+			Target: vec[i],
+			Expr:   vec[i+1],
+		})
+	}
+
+	return bindings, nil
+}
+
+// bindAll evaluates the bindings in order in a new local scope.
+func bindAll(scope sabre.Scope, bindings []binding) (*localScope, error) {
+	letScope := newLocalScope(scope)
+	for _, b := range bindings {
+		v, err := b.Expr.Eval(letScope)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := destructure(letScope, b.Target, v); err != nil {
+			return nil, err
+		}
+	}
+
+	return letScope, nil
+}
+
 func isRecur(value sabre.Value) bool {
 
 	list, ok := value.(*sabre.List)
@@ -462,9 +587,11 @@ func isRecur(value sabre.Value) bool {
 	return true
 }
 
+// binding is a pair of binding form and init expression of a bindings
+// vector. The binding form is a symbol or a destructuring pattern.
 type binding struct {
-	Name string
-	Expr sabre.Value
+	Target sabre.Value
+	Expr   sabre.Value
 }
 
 func and(x sabre.Value, y sabre.Value) bool {
@@ -477,18 +604,27 @@ func or(x sabre.Value, y sabre.Value) bool {
 
 func safeSwap(scope sabre.Scope, args []sabre.Value) (sabre.Value, error) {
 
-	atom := args[0]
-	atom, err := atom.Eval(scope)
+	v, err := args[0].Eval(scope)
 	if err != nil {
-		return nil, fmt.Errorf("unable to resolve symbol")
+		return nil, err
+	}
+
+	atom, ok := v.(*Atom)
+	if !ok {
+		return nil, fmt.Errorf("swap!: %s is not an atom", v)
 	}
 
-	fn, err := args[1].Eval(scope)
+	v, err = args[1].Eval(scope)
 	if err != nil {
-		return nil, fmt.Errorf("unable to resolve symbol")
+		return nil, err
 	}
 
-	return atom.(*Atom).UpdateState(scope, fn.(sabre.Invokable))
+	fn, ok := v.(sabre.Invokable)
+	if !ok {
+		return nil, fmt.Errorf("swap!: %s is not invokable", v)
+	}
+
+	return atom.UpdateState(scope, fn)
 }
 
 func bound(scope sabre.Scope) func(sabre.Symbol) bool {
@@ -504,3 +640,179 @@ func resolve(scope sabre.Scope) func(sabre.Symbol) sabre.Value {
 		return val
 	}
 }
+
+// get implements (get coll key) and (get coll key not-found). Maps are
+// looked up by key, vectors by index and sets by member. not-found, or nil
+// if not given, is returned for missing keys and values which are not
+// collections.
+func get(coll, key sabre.Value, notFound ...sabre.Value) (sabre.Value, error) {
+	if len(notFound) > 1 {
+		return nil, fmt.Errorf("wrong number of args (%d) to 'get'", len(notFound)+2)
+	}
+
+	if v, found := lookup(coll, key); found {
+		return v, nil
+	}
+
+	if len(notFound) == 1 {
+		return notFound[0], nil
+	}
+
+	return sabre.Nil{}, nil
+}
+
+// getIn implements (get-in coll keys) and (get-in coll keys not-found)
+// which look up the keys in nested collections.
+func getIn(coll, keys sabre.Value, notFound ...sabre.Value) (sabre.Value, error) {
+	if len(notFound) > 1 {
+		return nil, fmt.Errorf("wrong number of args (%d) to 'get-in'", len(notFound)+2)
+	}
+
+	found := true
+	err := forEach(keys, func(key sabre.Value) error {
+		if found {
+			coll, found = lookup(coll, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case found:
+		return coll, nil
+	case len(notFound) == 1:
+		return notFound[0], nil
+	}
+
+	return sabre.Nil{}, nil
+}
+
+func lookup(coll, key sabre.Value) (sabre.Value, bool) {
+	switch c := coll.(type) {
+	case *HashMap:
+		return c.Lookup(key)
+
+	case *Set:
+		if c.Contains(key) {
+			return key, true
+		}
+
+	case *Vector:
+		if i, ok := vectorIndex(c, key); ok {
+			return c.Nth(i), true
+		}
+	}
+
+	return nil, false
+}
+
+// containsKey implements (contains? coll key). For vectors, key is an
+// index.
+func containsKey(coll, key sabre.Value) (bool, error) {
+	switch c := coll.(type) {
+	case sabre.Nil:
+		return false, nil
+
+	case *HashMap:
+		return c.Contains(key), nil
+
+	case *Set:
+		return c.Contains(key), nil
+
+	case *Vector:
+		_, ok := vectorIndex(c, key)
+		return ok, nil
+	}
+
+	return false, fmt.Errorf("contains? not supported on type %s",
+		reflect.TypeOf(coll))
+}
+
+func vectorIndex(vec *Vector, key sabre.Value) (int, bool) {
+	i, isInt := key.(sabre.Int64)
+	if !isInt || i < 0 || int(i) >= vec.Size() {
+		return 0, false
+	}
+	return int(i), true
+}
+
+// assoc implements (assoc coll key val & kvs). nil is treated as an empty
+// map and vectors are updated by index.
+func assoc(coll sabre.Value, kvs ...sabre.Value) (sabre.Value, error) {
+	if len(kvs) == 0 || len(kvs)%2 != 0 {
+		return nil, fmt.Errorf("assoc expects even number of arguments after map/vector, got %d",
+			len(kvs))
+	}
+
+	switch c := coll.(type) {
+	case sabre.Nil:
+		return NewHashMap(kvs...), nil
+
+	case *HashMap:
+		for i := 0; i < len(kvs); i += 2 {
+			c = c.Assoc(kvs[i], kvs[i+1])
+		}
+		return c, nil
+
+	case *Vector:
+		for i := 0; i < len(kvs); i += 2 {
+			idx, isInt := kvs[i].(sabre.Int64)
+			if !isInt {
+				return nil, fmt.Errorf("vector index must be an integer, not %s",
+					reflect.TypeOf(kvs[i]))
+			}
+
+			var err error
+			if c, err = c.Assoc(int(idx), kvs[i+1]); err != nil {
+				return nil, err
+			}
+		}
+		return c, nil
+	}
+
+	return nil, fmt.Errorf("cannot assoc on type %s", reflect.TypeOf(coll))
+}
+
+// dissoc implements (dissoc map & keys).
+func dissoc(coll sabre.Value, keys ...sabre.Value) (sabre.Value, error) {
+	switch c := coll.(type) {
+	case sabre.Nil:
+		return c, nil
+
+	case *HashMap:
+		for _, k := range keys {
+			c = c.Dissoc(k)
+		}
+		return c, nil
+	}
+
+	return nil, fmt.Errorf("cannot dissoc on type %s", reflect.TypeOf(coll))
+}
+
+// mapKeys implements (keys map). Returns nil for empty maps.
+func mapKeys(coll sabre.Value) (sabre.Value, error) {
+	return mapSeq(coll, (*HashMap).Keys)
+}
+
+// mapVals implements (vals map). Returns nil for empty maps.
+func mapVals(coll sabre.Value) (sabre.Value, error) {
+	return mapSeq(coll, (*HashMap).Vals)
+}
+
+func mapSeq(coll sabre.Value, values func(*HashMap) []sabre.Value) (sabre.Value, error) {
+	switch c := coll.(type) {
+	case sabre.Nil:
+		return c, nil
+
+	case *HashMap:
+		if c.Size() == 0 {
+			return sabre.Nil{}, nil
+		}
+		return &sabre.List{Values: values(c)}, nil
+	}
+
+	return nil, fmt.Errorf("argument must be a hash-map, not %s",
+		reflect.TypeOf(coll))
+}
@@ -0,0 +1,411 @@
+package xlisp
+
+import (
+	"fmt"
+	"reflect"
+	"sync/atomic"
+
+	"github.com/spy16/sabre"
+)
+
+// Binding forms of let, loop, doseq and function parameters may be
+// destructuring patterns instead of plain symbols:
+//
+//	[a b & rest :as all]               sequential destructuring
+//	{:keys [x y] :or {x 1} :as m}      map destructuring by keyword
+//	{:strs [x] :syms [y]}              map destructuring by string or symbol
+//	{a :a [b c] :bc}                   map destructuring by explicit key
+//
+// Patterns nest arbitrarily. Symbols without a corresponding value are bound
+// to nil unless a default is given through :or. & binds a sequence of the
+// remaining values, and a map pattern applied to a sequence of key value
+// pairs destructures them as keyword arguments, as in [a & {:keys [b]}].
+
+// checkPattern returns an error if the form is not a valid binding form.
+func checkPattern(form sabre.Value) error {
+	switch p := form.(type) {
+	case sabre.Symbol:
+		if p.Value == "&" {
+			return fmt.Errorf("invalid binding form: &")
+		}
+		return nil
+
+	case *HashMap:
+		return checkMapPattern(p)
+	}
+
+	if forms, isVector := formValues(form); isVector {
+		return checkSeqPattern(forms)
+	}
+
+	return fmt.Errorf("invalid binding form: %s", form)
+}
+
+func checkSeqPattern(forms []sabre.Value) error {
+	for i := 0; i < len(forms); i++ {
+		switch {
+		case isSymbolNamed(forms[i], "&"):
+			if i+1 >= len(forms) {
+				return fmt.Errorf("missing binding form after &")
+			}
+
+			i++
+			if err := checkPattern(forms[i]); err != nil {
+				return err
+			}
+
+			if i+1 < len(forms) && !isKeywordNamed(forms[i+1], "as") {
+				return fmt.Errorf("unexpected binding form after & %s: %s",
+					forms[i], forms[i+1])
+			}
+
+		case isKeywordNamed(forms[i], "as"):
+			if i+2 != len(forms) {
+				return fmt.Errorf(":as must be followed by exactly one symbol")
+			}
+
+			i++
+			if _, ok := forms[i].(sabre.Symbol); !ok {
+				return fmt.Errorf(":as requires a symbol, not %s", forms[i])
+			}
+
+		default:
+			if err := checkPattern(forms[i]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func checkMapPattern(p *HashMap) error {
+	var err error
+	p.each(func(k, v sabre.Value) bool {
+		switch k {
+		case Keyword("keys"), Keyword("strs"), Keyword("syms"):
+			names, ok := formValues(v)
+			if !ok {
+				err = fmt.Errorf("%s requires a vector of symbols, not %s", k, v)
+				return false
+			}
+
+			for _, name := range names {
+				if _, ok := name.(sabre.Symbol); !ok {
+					err = fmt.Errorf("%s requires a vector of symbols, not %s", k, v)
+					return false
+				}
+			}
+
+		case Keyword("as"):
+			if _, ok := v.(sabre.Symbol); !ok {
+				err = fmt.Errorf(":as requires a symbol, not %s", v)
+			}
+
+		case Keyword("or"):
+			if _, ok := v.(*HashMap); !ok {
+				err = fmt.Errorf(":or requires a map, not %s", v)
+			}
+
+		default:
+			err = checkPattern(k)
+		}
+
+		return err == nil
+	})
+
+	return err
+}
+
+// destructure binds the symbols in the pattern to the corresponding parts
+// of the value in the scope. Defaults given through :or are evaluated in
+// the scope. Errors are reported at the position of the pattern.
+func destructure(scope *localScope, pattern, v sabre.Value) error {
+	var err error
+	switch p := pattern.(type) {
+	case sabre.Symbol:
+		return scope.Bind(p.Value, v)
+
+	case *HashMap:
+		err = destructureMap(scope, p, v)
+
+	default:
+		forms, isVector := formValues(pattern)
+		if !isVector {
+			return fmt.Errorf("invalid binding form: %s", pattern)
+		}
+		err = destructureSeq(scope, forms, v)
+	}
+
+	return atForm(pattern, err)
+}
+
+func destructureSeq(scope *localScope, forms []sabre.Value, v sabre.Value) error {
+	switch v.(type) {
+	case sabre.Nil, sabre.Seq:
+
+	default:
+		return fmt.Errorf("cannot destructure value of type %s as a sequence",
+			reflect.TypeOf(v))
+	}
+
+	seq, err := toSeq(v)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < len(forms); i++ {
+		switch {
+		case isSymbolNamed(forms[i], "&"):
+			var rest sabre.Value = sabre.Nil{}
+			if seq != nil {
+				rest = asSeq(seq)
+			}
+
+			i++
+			if err := destructure(scope, forms[i], rest); err != nil {
+				return err
+			}
+
+		case isKeywordNamed(forms[i], "as"):
+			i++
+			if err := destructure(scope, forms[i], v); err != nil {
+				return err
+			}
+
+		default:
+			var item sabre.Value = sabre.Nil{}
+			if seq != nil {
+				if first := seq.First(); first != nil {
+					item = first
+				}
+
+				if seq, err = nextSeq(seq); err != nil {
+					return err
+				}
+			}
+
+			if err := destructure(scope, forms[i], item); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func destructureMap(scope *localScope, p *HashMap, v sabre.Value) error {
+	var m *HashMap
+	whole := v
+	switch val := v.(type) {
+	case sabre.Nil:
+		m = NewHashMap()
+
+	case *HashMap:
+		m = val
+
+	default:
+		if !isSequential(v) {
+			return fmt.Errorf("cannot destructure value of type %s as a map",
+				reflect.TypeOf(v))
+		}
+
+		// keyword arguments such as the rest of (f 1 :b 2) for the
+		// parameters [a & {:keys [b]}].
+		kvs, err := seqValues(v)
+		if err != nil {
+			return err
+		}
+
+		if len(kvs)%2 != 0 {
+			return fmt.Errorf("cannot destructure %d values as a map: "+
+				"keyword arguments must come in pairs", len(kvs))
+		}
+
+		m = NewHashMap(kvs...)
+		whole = m
+	}
+
+	defaults := map[string]sabre.Value{}
+	if or, ok := p.Get(Keyword("or"), nil).(*HashMap); ok {
+		or.each(func(k, expr sabre.Value) bool {
+			if sym, ok := k.(sabre.Symbol); ok {
+				defaults[sym.Value] = expr
+			}
+			return true
+		})
+	}
+
+	bind := func(target, key sabre.Value) error {
+		val, found := m.Lookup(key)
+		if !found {
+			val = sabre.Nil{}
+			if sym, ok := target.(sabre.Symbol); ok {
+				if expr, hasDefault := defaults[sym.Value]; hasDefault {
+					var err error
+					if val, err = expr.Eval(scope); err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		return destructure(scope, target, val)
+	}
+
+	var err error
+	p.each(func(k, target sabre.Value) bool {
+		switch k {
+		case Keyword("keys"), Keyword("strs"), Keyword("syms"):
+			names, _ := formValues(target)
+			for _, name := range names {
+				sym := name.(sabre.Symbol)
+
+				var key sabre.Value
+				switch k {
+				case Keyword("keys"):
+					key = Keyword(sym.Value)
+				case Keyword("strs"):
+					key = sabre.String(sym.Value)
+				default:
+					key = sabre.Symbol{Value: sym.Value}
+				}
+
+				if err = bind(sym, key); err != nil {
+					return false
+				}
+			}
+
+		case Keyword("as"):
+			err = destructure(scope, target, whole)
+
+		case Keyword("or"):
+
+		default:
+			var key sabre.Value
+			if key, err = target.Eval(scope); err == nil {
+				err = bind(k, key)
+			}
+		}
+
+		return err == nil
+	})
+
+	return err
+}
+
+var patternCount uint64
+
+// expandParams replaces the destructuring patterns in the parameter vector
+// of a function method with generated symbols and wraps the body in a let
+// form destructuring the arguments bound to them.
+func expandParams(params []sabre.Value, body []sabre.Value) (sabre.Vector, []sabre.Value, error) {
+	var lets []sabre.Value
+	expanded := make([]sabre.Value, len(params))
+
+	for i, param := range params {
+		if _, isSymbol := param.(sabre.Symbol); isSymbol {
+			expanded[i] = param
+			continue
+		}
+
+		if err := checkPattern(param); err != nil {
+			return sabre.Vector{}, nil, err
+		}
+
+		n := atomic.AddUint64(&patternCount, 1)
+		sym := sabre.Symbol{Value: fmt.Sprintf("p__%d", n)}
+
+		expanded[i] = sym
+		lets = append(lets, param, sym)
+	}
+
+	if len(lets) == 0 {
+		return sabre.Vector{Values: expanded}, body, nil
+	}
+
+	// the let takes the position of the first pattern so that errors
+	// raised while binding the parameters point into the parameter vector.
+	let := &sabre.List{
+		Values: append([]sabre.Value{
+			sabre.Symbol{Value: "core/let"},
+			NewVector(lets...),
+		}, body...),
+		Position: formPosition(lets[0]),
+	}
+
+	return sabre.Vector{Values: expanded}, []sabre.Value{let}, nil
+}
+
+// expandLambda applies expandParams to all the methods of a fn* or macro*
+// form. The parameter vectors are returned as the sabre.Vector forms
+// expected by the sabre function parser.
+func expandLambda(forms []sabre.Value) ([]sabre.Value, error) {
+	expanded := append([]sabre.Value(nil), forms...)
+
+	i := 0
+	if _, isName := forms[0].(sabre.Symbol); isName {
+		i++
+	}
+
+	if i >= len(forms) {
+		return forms, nil
+	}
+
+	if params, isVector := formValues(forms[i]); isVector {
+		params, body, err := expandParams(params, forms[i+1:])
+		if err != nil {
+			return nil, err
+		}
+
+		return append(append(expanded[:i], params), body...), nil
+	}
+
+	for j := i; j < len(forms); j++ {
+		method, isList := forms[j].(*sabre.List)
+		if !isList || method.Size() == 0 {
+			continue
+		}
+
+		params, isVector := formValues(method.Values[0])
+		if !isVector {
+			continue
+		}
+
+		vec, body, err := expandParams(params, method.Values[1:])
+		if err != nil {
+			return nil, err
+		}
+
+		expanded[j] = &sabre.List{
+			Values:   append([]sabre.Value{vec}, body...),
+			Position: method.Position,
+		}
+	}
+
+	return expanded, nil
+}
+
+// asSeq returns the collection as a sequence of its values, so that & binds
+// a sequence even when no value precedes it in the pattern.
+func asSeq(s sabre.Seq) sabre.Seq {
+	switch coll := s.(type) {
+	case *Vector:
+		return &vectorSeq{vec: coll}
+
+	case *HashMap, *Set, sabre.String:
+		return &Cons{first: coll.First(), rest: coll.Next()}
+	}
+
+	return s
+}
+
+func isSymbolNamed(v sabre.Value, name string) bool {
+	sym, ok := v.(sabre.Symbol)
+	return ok && sym.Value == name
+}
+
+func isKeywordNamed(v sabre.Value, name string) bool {
+	kw, ok := v.(Keyword)
+	return ok && string(kw) == name
+}
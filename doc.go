@@ -0,0 +1,530 @@
+package xlisp
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/spy16/sabre"
+)
+
+// builtinDoc documents a value bound from Go. The arglists are written as
+// a list of parameter vectors, like the :arglists metadata of functions
+// defined with defn.
+type builtinDoc struct {
+	arglists string
+	doc      string
+}
+
+// builtinDocs holds the documentation of the values bound by BindAll,
+// bindNS and bindStreams, which is attached to their vars as :doc and
+// :arglists metadata.
+var builtinDocs = map[string]builtinDoc{
+	// gui frontend
+	"tview/new-app":               {"([])", "Returns a new tview application."},
+	"tview/app-set-before-draw":   {"([app f])", "Sets f to be called with the screen before the application is drawn. Drawing is skipped if f returns true."},
+	"tview/new-form":              {"([])", "Returns a new tview form."},
+	"tview/new-box":               {"([])", "Returns a new tview box."},
+	"tview/new-textview":          {"([])", "Returns a new tview text view."},
+	"tview/new-list":              {"([])", "Returns a new tview list."},
+	"tview/list-add-item":         {"([list text secondary-text shortcut f])", "Adds an item to the list. f is called when the item is selected."},
+	"tview/color-default":         {"", "The default color of the terminal."},
+	"tview/color-green":           {"", "The color green."},
+	"tview/color-red":             {"", "The color red."},
+	"tview/app-set-input-capture": {"([app f])", "Sets f to be called with each key event before the application handles it. f returns the event to handle."},
+
+	// built-in
+	"core/range":       {"([] [end] [start end] [start end step])", "Returns a lazy sequence of the integers from start, 0 by default, up to but excluding end, by step, 1 by default. Without end the sequence is infinite."},
+	"core/iterate":     {"([f x])", "Returns the infinite lazy sequence x, (f x), (f (f x)) and so on."},
+	"core/repeat":      {"([x] [n x])", "Returns a lazy sequence of n, or infinitely many, x."},
+	"core/cycle":       {"([coll])", "Returns an infinite lazy sequence repeating the values of coll."},
+	"core/seq":         {"([coll])", "Returns the values of coll as a sequence or nil if coll is empty."},
+	"core/first":       {"([coll])", "Returns the first value of coll or nil if it is empty."},
+	"core/next":        {"([coll])", "Returns the values after the first value of coll or nil if there are none."},
+	"core/rest":        {"([coll])", "Returns the values after the first value of coll, an empty list if there are none."},
+	"core/count":       {"([coll])", "Returns the number of values in coll."},
+	"core/conj":        {"([coll & xs])", "Returns coll with the values added. Lists and vectors get the values at the end, maps take [key value] vectors or maps."},
+	"core/apply":       {"([f & args coll])", "Calls f with the args followed by the values of coll."},
+	"core/vector":      {"([& xs])", "Returns a vector of the arguments."},
+	"core/vec":         {"([coll])", "Returns a vector of the values of coll."},
+	"core/hash-map":    {"([& kvs])", "Returns a hash-map of the alternating keys and values."},
+	"core/hash-set":    {"([& xs])", "Returns a set of the arguments."},
+	"core/set":         {"([coll])", "Returns a set of the distinct values of coll."},
+	"core/get":         {"([coll key] [coll key not-found])", "Returns the value of key in the map, set or vector, or not-found, nil by default, if the key is not present."},
+	"core/get-in":      {"([coll ks] [coll ks not-found])", "Returns the value found by looking up each key of ks in turn in nested collections, or not-found if a key is not present."},
+	"core/assoc":       {"([coll key val & kvs])", "Returns the map or vector with the keys associated to the values. nil is treated as an empty map."},
+	"core/dissoc":      {"([coll & ks])", "Returns the map without the keys."},
+	"core/keys":        {"([m])", "Returns the keys of the map."},
+	"core/vals":        {"([m])", "Returns the values of the map."},
+	"core/contains?":   {"([coll key])", "Returns true if the key is present in the map or set, or is a valid index of the vector."},
+	"core/transient":   {"([coll])", "Returns a transient version of the vector, map or set which can be updated in place with conj!, assoc!, dissoc! and disj!."},
+	"core/persistent!": {"([coll])", "Returns a persistent collection holding the values of the transient. The transient can no longer be used."},
+	"core/conj!":       {"([coll & xs])", "Adds the values to the transient and returns it."},
+	"core/assoc!":      {"([coll key val & kvs])", "Associates the keys to the values in the transient map or vector and returns it."},
+	"core/dissoc!":     {"([coll & ks])", "Removes the keys from the transient map and returns it."},
+	"core/disj!":       {"([coll & xs])", "Removes the values from the transient set and returns it."},
+	"core/lazy-seq":    {"([& body])", "Returns a lazy sequence whose values are computed by evaluating body when it is first accessed. body must return a sequence or nil. body sees the dynamic bindings in effect where the sequence is created, see binding."},
+	"core/future*":     {"([expr])", "Evaluates expr in another goroutine and returns a future of its result. See future."},
+	"core/time":        {"([& body])", "Evaluates body, prints the time it took and returns its result."},
+	"core/bounded?":    {"([sym])", "Returns true if the symbol resolves to a value."},
+	"core/sleep":       {"([ms])", "Pauses for ms milliseconds or until the evaluation is cancelled."},
+	"core/deref*":      {"([ref])", "Returns the value of the atom, var or future, blocking until the future is resolved. See deref."},
+	"core/doseq":       {"([[x coll] & body])", "Evaluates body with x bound to each value of coll in turn. Returns nil."},
+	"unsafe/swap":      {"([sym expr])", "Rebinds the local binding of sym to the value of expr."},
+	"core/atom":        {"([x])", "Returns an atom holding x."},
+	"core/swap!":       {"([atom f])", "Sets the value of the atom to (f value) and returns the new value."},
+	"core/and*":        {"([x y])", "Returns true if both x and y are true. See and."},
+	"core/or*":         {"([x y])", "Returns true if x or y is true. See or."},
+	"core/->":          {"([x & forms])", "Threads x through the forms, inserting it as the first argument of the first form, then the result as the first argument of the next form and so on."},
+	"core/->>":         {"([x & forms])", "Threads x through the forms like -> but inserts it as the last argument of each form."},
+	"core/case":        {"([expr & clauses])", "Evaluates expr and returns the result expression of the first test-constant result-expr pair of clauses whose constant equals the value, or the final default expression."},
+	"core/let":         {"([[bindings*] & body])", "Evaluates body with the symbols, or destructuring patterns, of the bindings bound to the values of their expressions."},
+	"core/loop":        {"([[bindings*] & body])", "Like let but recur in body rebinds the bindings and evaluates body again."},
+	"core/try":         {"([& body])", "Evaluates the expressions of body. An error is bound to the symbol of a final (catch e expr*) clause which is evaluated instead. The expressions of a (finally expr*) clause are always evaluated."},
+
+	// special forms
+	"core/do":           {"([& exprs])", "Evaluates the expressions in order and returns the value of the last one."},
+	"core/def":          {"([name value] [name doc value])", "Binds name to the value in the current namespace. Metadata attached to name with ^, such as ^:private or ^:dynamic, and the docstring are attached to the var."},
+	"core/var":          {"([sym])", "Returns the var the symbol refers to, also written #'sym."},
+	"core/binding":      {"([[bindings*] & body])", "Evaluates body with the dynamic vars of the bindings bound to the values of their expressions. Like futures, lazy sequences keep the bindings in effect where they are created: a lazy sequence created in body and realized after it sees the bindings, and one created before body and realized in it does not."},
+	"core/if":           {"([test then] [test then else])", "Evaluates then if test is truthy, else otherwise."},
+	"core/fn*":          {"([name? [params*] & body] [name? & methods])", "Returns a function. Each method is a list of a parameter vector followed by a body."},
+	"core/macro*":       {"([name? [params*] & body] [name? & methods])", "Returns a macro, which is like a function called with its unevaluated arguments and returning the form to evaluate."},
+	"core/quote":        {"([form])", "Returns form without evaluating it, also written 'form."},
+	"core/syntax-quote": {"([form])", "Returns form without evaluating it except for the forms unquoted with ~, also written `form."},
+	"core/recur":        {"([& exprs])", "Evaluates the function or loop body again with the values of the expressions."},
+
+	"core/macroexpand": {"([form])", "Returns form with its macro call expanded."},
+	"core/eval":        {"([form])", "Evaluates the form."},
+	"core/eval-string": {"([s])", "Reads all the forms in the string, evaluates them and returns the value of the last one."},
+	"core/type":        {"([x])", "Returns the type of x."},
+	"core/to-type":     {"([type x])", "Converts x to the type."},
+	"core/impl?":       {"([x type])", "Returns true if x implements the interface type."},
+	"core/realized*":   {"([f])", "Returns true if the future has been resolved. See realized?."},
+	"core/throw":       {"([& args])", "Throws the exception, or an exception whose message is made of the args converted to strings."},
+	"core/ex-info":     {"([msg data] [msg data cause])", "Returns an exception with the message, the map of data and optionally its cause."},
+	"core/ex-data":     {"([ex])", "Returns the data of an exception created by ex-info or nil."},
+	"core/ex-message":  {"([ex])", "Returns the message of the exception or nil if ex is not an exception."},
+	"core/ex-cause":    {"([ex])", "Returns the cause of the exception or nil."},
+	"core/with-meta":   {"([obj meta])", "Returns obj with the metadata map attached, nil removes it. Only functions, vectors, maps and sets support metadata: lists, which are also code, and other values do not, and attaching metadata to them is an error."},
+	"core/meta":        {"([obj])", "Returns the metadata of the value or var, or nil."},
+	"core/defn*":       {"([kind name fdecl] [kind name fdecl attrs])", "Returns the def form of defn and defmacro."},
+	"core/substring":   {"([s substr])", "Returns true if s contains substr."},
+	"core/trim-suffix": {"([s suffix])", "Returns s without the suffix."},
+	"core/resolve":     {"([sym])", "Returns the value the symbol resolves to or nil."},
+
+	// Type system functions
+	"core/str": {"([& xs])", "Returns the concatenation of the values converted to strings. nil is converted to an empty string."},
+
+	// Math functions
+	"core/+":           {"([& xs])", "Returns the sum of the numbers, 0 without numbers. Throws on integer overflow."},
+	"core/-":           {"([x & xs])", "Returns x minus the other numbers, or the negation of x. Throws on integer overflow."},
+	"core/*":           {"([& xs])", "Returns the product of the numbers, 1 without numbers. Throws on integer overflow."},
+	"core//":           {"([x & xs])", "Returns x divided by the other numbers, or 1/x. Division of integers returns a ratio unless exact."},
+	"core/+'":          {"([& xs])", "Like + but promotes to BigInt on integer overflow."},
+	"core/-'":          {"([x & xs])", "Like - but promotes to BigInt on integer overflow."},
+	"core/*'":          {"([& xs])", "Like * but promotes to BigInt on integer overflow."},
+	"core/mod":         {"([num div])", "Returns the modulus of num and div, which has the sign of div."},
+	"core/==":          {"([x & xs])", "Returns true if the numbers are equal in value regardless of their types."},
+	"core/int":         {"([x])", "Converts the number or character to an integer, truncating towards zero."},
+	"core/float":       {"([x])", "Converts the number to a float."},
+	"core/bigint":      {"([x])", "Converts the number to a BigInt."},
+	"core/bigdec":      {"([x])", "Converts the number to a BigDecimal."},
+	"core/numerator":   {"([r])", "Returns the numerator of the ratio."},
+	"core/denominator": {"([r])", "Returns the denominator of the ratio."},
+	"core/=":           {"([x y])", "Returns true if x equals y. Collections are equal if they hold equal values."},
+	"core/>":           {"([x & xs])", "Returns true if the values are in decreasing order."},
+	"core/>=":          {"([x & xs])", "Returns true if the values are in non-increasing order."},
+	"core/<":           {"([x & xs])", "Returns true if the values are in increasing order."},
+	"core/<=":          {"([x & xs])", "Returns true if the values are in non-decreasing order."},
+	"core/compare":     {"([x y])", "Returns -1, 0 or 1 if x is less than, equal to or greater than y. Numbers, strings, characters, keywords, symbols, booleans, vectors and values implementing types/Comparable can be compared, nil is less than any value."},
+
+	// regular expressions
+	"core/re-pattern": {"([s])", "Returns the regex compiled from the string."},
+	"core/re-find":    {"([re s])", "Returns the first match of the regex in s or nil. The match is a vector of the match and its groups if the regex has groups."},
+	"core/re-matches": {"([re s])", "Like re-find but matches only if the whole string matches the regex."},
+	"core/re-seq":     {"([re s])", "Returns the successive matches of the regex in s."},
+	"core/re-groups":  {"([re s])", "Returns a map of the groups of the first match of the regex in s, or nil. Named groups are keyed by keywords and the others by their index."},
+
+	// sequence functions
+	"core/sort":         {"([coll] [comp coll])", "Returns the values of coll sorted using compare or the comparator. The sort is stable."},
+	"core/sort-by":      {"([keyfn coll] [keyfn comp coll])", "Returns the values of coll sorted by (keyfn value) using compare or the comparator. The sort is stable."},
+	"core/group-by":     {"([f coll])", "Returns a map from the results of f to vectors of the values of coll producing them."},
+	"core/frequencies":  {"([coll])", "Returns a map from the distinct values of coll to the number of times they appear."},
+	"core/partition":    {"([n coll] [n step coll] [n step pad coll])", "Returns a lazy sequence of lists of n values of coll, starting step values apart. The last partition is filled up with pad, or dropped if incomplete."},
+	"core/partition-by": {"([f coll])", "Returns a lazy sequence of lists splitting coll each time f returns a new value."},
+	"core/distinct":     {"([coll])", "Returns a lazy sequence of the values of coll without duplicates."},
+	"core/dedupe":       {"([coll])", "Returns a lazy sequence of the values of coll without consecutive duplicates."},
+	"core/interleave":   {"([& colls])", "Returns a lazy sequence of the first value of each collection, then the second and so on."},
+	"core/zipmap":       {"([keys vals])", "Returns a map with the keys associated to the corresponding vals."},
+	"core/flatten":      {"([coll])", "Returns a lazy sequence of the values nested in sequential collections of coll."},
+
+	// io functions
+	"core/$":            {"([command])", "Runs the shell command and returns a map of its :exit code, :out and :err."},
+	"core/print":        {"([& xs])", "Writes the values separated by spaces and followed by a newline to *out*."},
+	"core/printf":       {"([format & args])", "Writes the formatted string to *out*."},
+	"core/eprint":       {"([& xs])", "Writes the values separated by spaces and followed by a newline to *err*."},
+	"core/eprintf":      {"([format & args])", "Writes the formatted string to *err*."},
+	"core/read*":        {"([prompt])", "Writes the prompt to *out* and returns a line read from *in*. See read."},
+	"core/random":       {"([max])", "Returns a random integer in [0, max)."},
+	"core/shuffle":      {"([coll])", "Returns the values of coll in random order."},
+	"core/read-file":    {"([name])", "Returns the content of the file."},
+	"core/load-file":    {"([name])", "Reads and evaluates all the forms of the file."},
+	"core/with-out-str": {"([& body])", "Evaluates body with *out* bound to a buffer and returns the content written to it."},
+	"core/with-in-str":  {"([s & body])", "Evaluates body with *in* bound to a stream reading from the string."},
+
+	// math
+	"math/abs":                      {"([x])", "Returns the absolute value of x."},
+	"math/min":                      {"([x & xs])", "Returns the least of the numbers."},
+	"math/max":                      {"([x & xs])", "Returns the greatest of the numbers."},
+	"math/pow":                      {"([x y])", "Returns x raised to the power y, exactly for integer powers of exact numbers."},
+	"math/sqrt":                     {"([x])", "Returns the square root of x."},
+	"math/cbrt":                     {"([x])", "Returns the cube root of x."},
+	"math/floor":                    {"([x])", "Returns the greatest integer less than or equal to x."},
+	"math/ceil":                     {"([x])", "Returns the least integer greater than or equal to x."},
+	"math/round":                    {"([x])", "Returns the integer closest to x, rounding halves up, towards positive infinity."},
+	"math/sin":                      {"([x])", "Returns the sine of x radians."},
+	"math/cos":                      {"([x])", "Returns the cosine of x radians."},
+	"math/tan":                      {"([x])", "Returns the tangent of x radians."},
+	"math/asin":                     {"([x])", "Returns the arcsine of x in radians."},
+	"math/acos":                     {"([x])", "Returns the arccosine of x in radians."},
+	"math/atan":                     {"([x])", "Returns the arctangent of x in radians."},
+	"math/atan2":                    {"([y x])", "Returns the angle in radians of the point (x, y)."},
+	"math/sinh":                     {"([x])", "Returns the hyperbolic sine of x."},
+	"math/cosh":                     {"([x])", "Returns the hyperbolic cosine of x."},
+	"math/tanh":                     {"([x])", "Returns the hyperbolic tangent of x."},
+	"math/exp":                      {"([x])", "Returns e raised to the power x."},
+	"math/log":                      {"([x])", "Returns the natural logarithm of x."},
+	"math/log10":                    {"([x])", "Returns the base 10 logarithm of x."},
+	"math/log2":                     {"([x])", "Returns the base 2 logarithm of x."},
+	"math/quot":                     {"([num div])", "Returns the quotient of num and div truncated towards zero."},
+	"math/rem":                      {"([num div])", "Returns the remainder of num and div, which has the sign of num."},
+	"math/mod":                      {"([num div])", "Returns the modulus of num and div, which has the sign of div."},
+	"math/bit-and":                  {"([x y & more])", "Returns the bitwise and of the integers."},
+	"math/bit-or":                   {"([x y & more])", "Returns the bitwise or of the integers."},
+	"math/bit-xor":                  {"([x y & more])", "Returns the bitwise exclusive or of the integers."},
+	"math/bit-not":                  {"([x])", "Returns the bitwise complement of the integer."},
+	"math/bit-shift-left":           {"([x n])", "Returns x shifted left by n bits."},
+	"math/bit-shift-right":          {"([x n])", "Returns x shifted right by n bits, keeping its sign."},
+	"math/unsigned-bit-shift-right": {"([x n])", "Returns x shifted right by n bits, filling with zeros."},
+	"math/NaN?":                     {"([x])", "Returns true if x is NaN."},
+	"math/infinite?":                {"([x])", "Returns true if x is positive or negative infinity."},
+	"math/PI":                       {"", "The ratio of the circumference of a circle to its diameter."},
+	"math/E":                        {"", "The base of the natural logarithm."},
+	"math/NaN":                      {"", "The float not-a-number value."},
+	"math/INF":                      {"", "The float positive infinity."},
+	"math/-INF":                     {"", "The float negative infinity."},
+	"math/MAX-INT":                  {"", "The greatest integer which is not a BigInt."},
+	"math/MIN-INT":                  {"", "The least integer which is not a BigInt."},
+
+	// strings
+	"string/split":         {"([s sep])", "Returns the substrings of s separated by sep."},
+	"string/split-lines":   {"([s])", "Returns the lines of s split on \\n or \\r\\n."},
+	"string/join":          {"([coll] [sep coll])", "Returns the values of coll converted to strings and joined with sep."},
+	"string/upper-case":    {"([s])", "Returns s in upper case."},
+	"string/lower-case":    {"([s])", "Returns s in lower case."},
+	"string/capitalize":    {"([s])", "Returns s with its first character in upper case and the rest in lower case."},
+	"string/trim":          {"([s])", "Returns s without leading and trailing whitespace."},
+	"string/triml":         {"([s])", "Returns s without leading whitespace."},
+	"string/trimr":         {"([s])", "Returns s without trailing whitespace."},
+	"string/replace":       {"([s match replacement])", "Replaces all the occurrences of the string or regex match in s. A regex replacement is a string which may refer to groups as $1, or a function called with each match."},
+	"string/includes?":     {"([s substr])", "Returns true if s contains substr."},
+	"string/starts-with?":  {"([s prefix])", "Returns true if s starts with prefix."},
+	"string/ends-with?":    {"([s suffix])", "Returns true if s ends with suffix."},
+	"string/index-of":      {"([s value] [s value from])", "Returns the index of the first occurrence of value in s at or after from, or nil."},
+	"string/last-index-of": {"([s value])", "Returns the index of the last occurrence of value in s, or nil."},
+	"string/subs":          {"([s start] [s start end])", "Returns the substring of s from start up to, but excluding, end."},
+	"string/blank?":        {"([s])", "Returns true if s is nil or only whitespace."},
+	"string/pad":           {"([s width] [s width padding])", "Returns s followed by the padding, a space by default, up to width characters."},
+	"string/pad-left":      {"([s width] [s width padding])", "Returns s preceded by the padding, a space by default, up to width characters."},
+	"string/repeat":        {"([s n])", "Returns s repeated n times."},
+	"string/reverse":       {"([s])", "Returns s with its characters in reverse order."},
+	"string/length":        {"([s])", "Returns the number of characters of s."},
+
+	"types/Seq":        {"", "The interface of sequences."},
+	"types/Exception":  {"", "The type of exceptions."},
+	"types/Future":     {"", "The type of futures."},
+	"types/LazySeq":    {"", "The type of lazy sequences."},
+	"types/InStream":   {"", "The type of input streams."},
+	"types/OutStream":  {"", "The type of output streams."},
+	"types/Invokable":  {"", "The interface of values which can be called like functions."},
+	"types/Comparable": {"", "The interface of values with a natural ordering, see compare."},
+	"types/Regex":      {"", "The type of regexes."},
+
+	// namespaces
+	"core/ns":          {"([name])", "Switches the current namespace."},
+	"core/*load-path*": {"", "The directories searched by require."},
+	"core/require":     {"([& specs])", "Loads the namespaces of the specs, such as [foo.bar :as bar :refer [baz]], unless already loaded."},
+	"core/all-ns":      {"([])", "Returns the names of all the namespaces."},
+	"core/ns-name":     {"([ns])", "Returns the name of the namespace as a symbol."},
+	"core/ns-publics":  {"([ns])", "Returns a map of the public vars of the namespace."},
+	"core/ns-interns":  {"([ns])", "Returns a map of all the vars of the namespace."},
+	"core/ns-resolve":  {"([ns sym])", "Returns the value the symbol resolves to in the namespace or nil."},
+	"core/ns-unmap":    {"([ns sym])", "Removes the var of the symbol from the namespace."},
+	"core/remove-ns":   {"([ns])", "Removes the namespace and returns true if it existed."},
+
+	// help
+	"core/doc*":     {"([v])", "Prints the documentation of the var. See doc."},
+	"core/find-doc": {"([pattern])", "Prints the documentation of the vars whose name or documentation matches the string or regex."},
+	"core/apropos":  {"([pattern])", "Returns the qualified names of the public vars whose name matches the string or regex."},
+	"core/source*":  {"([v])", "Prints the source of the var. See source."},
+
+	// streams
+	"core/" + symIn:  {"", "The stream read by read."},
+	"core/" + symOut: {"", "The stream written by print and printf."},
+	"core/" + symErr: {"", "The stream written by eprint and eprintf, and by the REPL for errors."},
+}
+
+// bindDocs binds the help functions and attaches the documentation of the
+// builtins to their vars.
+func (slang *Xlisp) bindDocs() error {
+	fns := map[string]sabre.Value{
+		"core/doc*":     sabre.ValueOf(slang.printDoc),
+		"core/find-doc": sabre.ValueOf(slang.findDoc),
+		"core/apropos":  sabre.ValueOf(slang.apropos),
+		"core/source*":  sabre.ValueOf(slang.printSource),
+	}
+
+	for sym, val := range fns {
+		if err := slang.Bind(sym, val); err != nil {
+			return err
+		}
+	}
+
+	slang.mu.Lock()
+	defer slang.mu.Unlock()
+
+	for sym, bd := range builtinDocs {
+		nsSym, err := splitSymbol("", sym)
+		if err != nil {
+			return err
+		}
+
+		vr, found := slang.bindings[*nsSym]
+		if !found {
+			return fmt.Errorf("cannot document unbound symbol %s", sym)
+		}
+
+		meta := NewHashMap(
+			Keyword("ns"), sabre.Symbol{Value: vr.NS},
+			Keyword("doc"), sabre.String(bd.doc),
+		)
+
+		if bd.arglists != "" {
+			arglists, err := NewReader(strings.NewReader(bd.arglists)).One()
+			if err != nil {
+				return fmt.Errorf("invalid arglists of %s: %v", sym, err)
+			}
+			meta = meta.Assoc(Keyword("arglists"), arglists)
+		}
+
+		vr.Meta = meta
+	}
+
+	return nil
+}
+
+// printDoc implements (doc* v) which writes the name, the arglists and the
+// docstring of the var to *out*.
+func (slang *Xlisp) printDoc(scope sabre.Scope, vr *Var) error {
+	out, err := stdout(scope)
+	if err != nil {
+		return err
+	}
+
+	return writeDoc(out, vr)
+}
+
+// findDoc implements (find-doc pattern) which writes the documentation of
+// the public vars whose qualified name or docstring matches the pattern.
+func (slang *Xlisp) findDoc(scope sabre.Scope, pattern sabre.Value) error {
+	re, err := rePattern(pattern)
+	if err != nil {
+		return err
+	}
+
+	out, err := stdout(scope)
+	if err != nil {
+		return err
+	}
+
+	for _, vr := range slang.publicVars() {
+		doc, _ := metaString(vr.Meta, "doc")
+		if !re.MatchString(vr.NS+"/"+vr.Name) && !re.MatchString(doc) {
+			continue
+		}
+
+		if err := writeDoc(out, vr); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// apropos implements (apropos pattern) which returns the qualified names
+// of the public vars whose name contains the string or matches the regex.
+func (slang *Xlisp) apropos(pattern sabre.Value) (*sabre.List, error) {
+	var match func(name string) bool
+	switch p := pattern.(type) {
+	case sabre.String:
+		match = func(name string) bool { return strings.Contains(name, string(p)) }
+
+	case *Regex:
+		match = p.MatchString
+
+	default:
+		return nil, fmt.Errorf("pattern must be a string or a regex, not %s",
+			reflect.TypeOf(pattern))
+	}
+
+	var names []sabre.Value
+	for _, vr := range slang.publicVars() {
+		if match(vr.Name) {
+			names = append(names, sabre.Symbol{Value: vr.NS + "/" + vr.Name})
+		}
+	}
+
+	return &sabre.List{Values: names}, nil
+}
+
+// printSource implements (source* v) which writes the source of the form
+// defining the var to *out*. Reading the source of vars defined outside of
+// the core library requires CapFilesystem.
+func (slang *Xlisp) printSource(scope sabre.Scope, vr *Var) error {
+	out, err := stdout(scope)
+	if err != nil {
+		return err
+	}
+
+	var line sabre.Int64
+	file, hasFile := metaString(vr.Meta, "file")
+	if hasFile {
+		line, hasFile = vr.Meta.Get(Keyword("line"), sabre.Nil{}).(sabre.Int64)
+	}
+
+	if !hasFile {
+		_, err := fmt.Fprintln(out, "Source not found")
+		return err
+	}
+
+	src := slang.coreSource
+	if file != coreFile {
+		if slang.caps&CapFilesystem == 0 {
+			return &CapabilityError{Capability: CapFilesystem, Name: "source"}
+		}
+
+		content, err := ioutil.ReadFile(file)
+		if os.IsNotExist(err) {
+			_, err := fmt.Fprintln(out, "Source not found")
+			return err
+		} else if err != nil {
+			return err
+		}
+		src = string(content)
+	}
+
+	form, err := formAt(src, int(line))
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(out, form)
+	return err
+}
+
+// publicVars returns the public vars of all the namespaces sorted by their
+// qualified names.
+func (slang *Xlisp) publicVars() []*Var {
+	slang.mu.RLock()
+	defer slang.mu.RUnlock()
+
+	var vars []*Var
+	for _, vr := range slang.bindings {
+		if !vr.Private {
+			vars = append(vars, vr)
+		}
+	}
+
+	sort.Slice(vars, func(i, j int) bool {
+		if vars[i].NS != vars[j].NS {
+			return vars[i].NS < vars[j].NS
+		}
+		return vars[i].Name < vars[j].Name
+	})
+
+	return vars
+}
+
+// writeDoc writes the documentation of the var in the following format.
+//
+//	-------------------------
+//	core/inc
+//	([num])
+//	  Returns num plus one.
+func writeDoc(w io.Writer, vr *Var) error {
+	var sb strings.Builder
+	sb.WriteString("-------------------------\n")
+	sb.WriteString(vr.NS + "/" + vr.Name + "\n")
+
+	switch v := vr.Value.(type) {
+	case sabre.SpecialForm:
+		sb.WriteString("Special Form\n")
+
+	case sabre.MultiFn:
+		if v.IsMacro {
+			sb.WriteString("Macro\n")
+		}
+	}
+
+	if vr.Meta != nil {
+		if arglists, found := vr.Meta.Lookup(Keyword("arglists")); found {
+			sb.WriteString(arglists.String() + "\n")
+		}
+	}
+
+	if doc, found := metaString(vr.Meta, "doc"); found {
+		for _, line := range strings.Split(doc, "\n") {
+			sb.WriteString("  " + strings.TrimSpace(line) + "\n")
+		}
+	}
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// formAt returns the text of the form starting at the line of the source.
+// The text spans the whole lines the form is written on.
+func formAt(src string, line int) (string, error) {
+	lines := strings.SplitAfter(src, "\n")
+	if line < 1 || line > len(lines) {
+		return "", fmt.Errorf("line %d out of range", line)
+	}
+
+	text := strings.Join(lines[line-1:], "")
+	rd := NewReader(strings.NewReader(text))
+	if _, err := rd.One(); err != nil {
+		return "", err
+	}
+
+	end := line - 1 + rd.Position().Line
+	if end > len(lines) {
+		end = len(lines)
+	}
+
+	return strings.TrimRight(strings.Join(lines[line-1:end], ""), "\n"), nil
+}
+
+// metaString returns the string value of the key in the metadata.
+func metaString(meta *HashMap, key string) (string, bool) {
+	if meta == nil {
+		return "", false
+	}
+
+	s, isString := meta.Get(Keyword(key), sabre.Nil{}).(sabre.String)
+	return string(s), isString
+}
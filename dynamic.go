@@ -0,0 +1,109 @@
+package xlisp
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spy16/sabre"
+)
+
+// Binding rebinds dynamic vars for the extent of its body, e.g.
+// (binding [*out* w] (print 1)). The new values are visible to all the
+// functions called from the body, including the bodies of futures started
+// there, but not to other evaluations running concurrently.
+var Binding = sabre.SpecialForm{
+	Name:  "binding",
+	Parse: parseBinding,
+}
+
+func parseBinding(scope sabre.Scope, args []sabre.Value) (*sabre.Fn, error) {
+	bindings, err := parseBindings(args)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sabre.Fn{
+		Func: func(scope sabre.Scope, _ []sabre.Value) (sabre.Value, error) {
+			values := map[*Var]sabre.Value{}
+			for _, b := range bindings {
+				sym, isSymbol := b.Target.(sabre.Symbol)
+				if !isSymbol {
+					return nil, fmt.Errorf("binding requires symbols, not %s", b.Target)
+				}
+
+				vr, err := dynamicVar(scope, sym.Value)
+				if err != nil {
+					return nil, err
+				}
+
+				v, err := b.Expr.Eval(scope)
+				if err != nil {
+					return nil, err
+				}
+				values[vr] = v
+			}
+
+			return sabre.Module(args[1:]).Eval(bindDynamic(scope, values))
+		},
+	}, nil
+}
+
+// bindDynamic returns a scope in which the vars are bound to the values.
+func bindDynamic(scope sabre.Scope, values map[*Var]sabre.Value) *localScope {
+	s := newLocalScope(scope)
+	s.dynamic = values
+	return s
+}
+
+// bindDynamicSym is like bindDynamic for a single var given by its symbol.
+func bindDynamicSym(scope sabre.Scope, symbol string, v sabre.Value) (*localScope, error) {
+	vr, err := dynamicVar(scope, symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	return bindDynamic(scope, map[*Var]sabre.Value{vr: v}), nil
+}
+
+// dynamicVar returns the dynamic var the symbol refers to in the scope.
+func dynamicVar(scope sabre.Scope, symbol string) (*Var, error) {
+	xl := interpreter(scope)
+	if xl == nil {
+		return nil, fmt.Errorf("cannot bind %s: scope has no vars", symbol)
+	}
+
+	vr, _, err := xl.resolveVar(scopeNS(scope), symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	if !vr.Dynamic {
+		return nil, fmt.Errorf("cannot dynamically bind non-dynamic var: %s", vr)
+	}
+
+	return vr, nil
+}
+
+// dynamicValue returns the value the var is bound to by the innermost
+// binding form enclosing the scope.
+func dynamicValue(scope sabre.Scope, vr *Var) (sabre.Value, bool) {
+	for ; scope != nil; scope = scope.Parent() {
+		s, ok := scope.(*localScope)
+		if !ok {
+			continue
+		}
+
+		if v, found := s.dynamic[vr]; found {
+			return v, true
+		}
+	}
+
+	return nil, false
+}
+
+// isEarmuffed returns true if the name is enclosed in asterisks such as
+// *out*.
+func isEarmuffed(name string) bool {
+	return len(name) > 2 && strings.HasPrefix(name, "*") &&
+		strings.HasSuffix(name, "*")
+}
@@ -1,6 +1,11 @@
 package xlisp
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spy16/sabre"
+)
 
 func checkArity(expected, got int) error {
 
@@ -23,3 +28,114 @@ func checkArityAtLeast(atLeast, got int) error {
 
 	return nil
 }
+
+// maxTraceFrames limits the number of frames rendered by TraceError.
+const maxTraceFrames = 64
+
+// Frame represents a single entry in the stack trace of an evaluation error.
+// Frames without a name represent top-level forms.
+type Frame struct {
+	sabre.Position
+	Name string
+}
+
+func (f Frame) String() string {
+	if f.Name == "" {
+		return f.Position.String()
+	}
+
+	return fmt.Sprintf("%s (%s)", f.Name, f.Position)
+}
+
+// TraceError wraps an error raised during evaluation with the xlisp call
+// stack at the point of failure. Frames are ordered from the innermost call
+// to the outermost top-level form.
+type TraceError struct {
+	Cause  error
+	Frames []Frame
+}
+
+// Unwrap returns the underlying cause of this error.
+func (te *TraceError) Unwrap() error { return te.Cause }
+
+func (te *TraceError) Error() string {
+	var sb strings.Builder
+	sb.WriteString(te.Cause.Error())
+
+	for i := 0; i < len(te.Frames); i++ {
+		if i == maxTraceFrames {
+			fmt.Fprintf(&sb, "\n\t... %d more", len(te.Frames)-i)
+			break
+		}
+
+		frame := te.Frames[i]
+		repeated := 0
+		for i+1 < len(te.Frames) && te.Frames[i+1] == frame {
+			repeated++
+			i++
+		}
+
+		fmt.Fprintf(&sb, "\n\tat %s", frame)
+		if repeated > 0 {
+			fmt.Fprintf(&sb, " [repeated %d more times]", repeated)
+		}
+	}
+
+	return sb.String()
+}
+
+// addFrame records a frame with the given name at the position where err
+// was raised and returns the resulting TraceError. Since the returned error
+// is not a sabre.EvalError, the next enclosing form being evaluated will
+// wrap it with its own position which in turn becomes the position of the
+// caller's frame.
+func addFrame(name string, err error) error {
+	var pos sabre.Position
+	cause := err
+
+	if ee, ok := err.(sabre.EvalError); ok {
+		pos, cause = ee.Position, ee.Cause
+	}
+
+	te, ok := cause.(*TraceError)
+	if !ok {
+		te = &TraceError{Cause: cause}
+	}
+
+	te.Frames = append(te.Frames, Frame{Name: name, Position: pos})
+	return te
+}
+
+// atForm returns err attributed to the position of the form unless err
+// already carries a position or the form has none, in which case the
+// enclosing form being evaluated provides it.
+func atForm(form sabre.Value, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if _, ok := err.(sabre.EvalError); ok {
+		return err
+	}
+
+	pos := formPosition(form)
+	if pos == (sabre.Position{}) {
+		return err
+	}
+
+	return sabre.EvalError{Position: pos, Cause: err, Form: form}
+}
+
+// formPosition returns the reader position of the form, or the zero
+// position if the form does not record one.
+func formPosition(form sabre.Value) sabre.Position {
+	p, ok := form.(interface {
+		GetPos() (file string, line, col int)
+	})
+	if !ok {
+		return sabre.Position{}
+	}
+
+	file, line, col := p.GetPos()
+	return sabre.Position{File: file, Line: line, Column: col}
+}
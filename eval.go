@@ -0,0 +1,151 @@
+package xlisp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/spy16/sabre"
+)
+
+// Limits bound the resources a single evaluation may use. Zero values mean
+// no limit.
+type Limits struct {
+	// MaxSteps is the maximum number of function calls and loop iterations.
+	MaxSteps int64
+
+	// MaxDepth is the maximum number of nested function calls.
+	MaxDepth int
+
+	// Timeout is the maximum wall-clock time an evaluation may take.
+	Timeout time.Duration
+}
+
+// LimitError is returned when an evaluation exceeds one of its Limits.
+type LimitError struct {
+	// Limit is the name of the exceeded limit: steps, depth or timeout.
+	Limit string
+
+	// Max is the configured value of the limit. Timeout is in nanoseconds.
+	Max int64
+}
+
+func (e *LimitError) Error() string {
+	if e.Limit == "timeout" {
+		return fmt.Sprintf("evaluation exceeded timeout of %s",
+			time.Duration(e.Max))
+	}
+
+	return fmt.Sprintf("evaluation exceeded %s limit of %d", e.Limit, e.Max)
+}
+
+// evalState tracks a single evaluation started through EvalContext. It is
+// shared by all the scopes created during the evaluation, including the
+// ones used by futures started from it.
+type evalState struct {
+	ctx      context.Context
+	limits   Limits
+	deadline time.Time
+	steps    int64
+}
+
+func newEvalState(ctx context.Context, limits Limits) *evalState {
+	st := &evalState{ctx: ctx, limits: limits}
+	if limits.Timeout > 0 {
+		st.deadline = time.Now().Add(limits.Timeout)
+	}
+
+	return st
+}
+
+// check returns an error if the evaluation has been cancelled or has
+// exceeded its limits. It is called once per step with the current call
+// depth.
+func (st *evalState) check(depth int) error {
+	if err := st.ctx.Err(); err != nil {
+		return err
+	}
+
+	if max := st.limits.MaxSteps; max > 0 && atomic.AddInt64(&st.steps, 1) > max {
+		return &LimitError{Limit: "steps", Max: max}
+	}
+
+	if max := st.limits.MaxDepth; max > 0 && depth > max {
+		return &LimitError{Limit: "depth", Max: int64(max)}
+	}
+
+	if !st.deadline.IsZero() && time.Now().After(st.deadline) {
+		return &LimitError{Limit: "timeout", Max: int64(st.limits.Timeout)}
+	}
+
+	return nil
+}
+
+// evalScope is the scope in which the top-level forms of an evaluation are
+// evaluated. Bindings are delegated to the interpreter.
+type evalScope struct {
+	xl    *Xlisp
+	state *evalState
+}
+
+// Parent returns the interpreter as the parent scope.
+func (s *evalScope) Parent() sabre.Scope { return s.xl }
+
+// Bind binds the symbol in the interpreter.
+func (s *evalScope) Bind(symbol string, v sabre.Value) error {
+	return s.xl.Bind(symbol, v)
+}
+
+// Resolve resolves the symbol in the interpreter.
+func (s *evalScope) Resolve(symbol string) (sabre.Value, error) {
+	return s.xl.Resolve(symbol)
+}
+
+// stateOf returns the state of the evaluation the scope belongs to or nil
+// if the scope is not part of an evaluation started through EvalContext.
+func stateOf(scope sabre.Scope) *evalState {
+	for ; scope != nil; scope = scope.Parent() {
+		switch s := scope.(type) {
+		case *localScope:
+			return s.state
+
+		case *evalScope:
+			return s.state
+		}
+	}
+
+	return nil
+}
+
+// depthOf returns the number of nested function calls the scope is in.
+func depthOf(scope sabre.Scope) int {
+	for ; scope != nil; scope = scope.Parent() {
+		if s, ok := scope.(*localScope); ok {
+			return s.depth
+		}
+	}
+
+	return 0
+}
+
+// checkEval returns an error if the evaluation the scope belongs to has
+// been cancelled or has exceeded its limits.
+func checkEval(scope sabre.Scope) error {
+	st := stateOf(scope)
+	if st == nil {
+		return nil
+	}
+
+	return st.check(depthOf(scope))
+}
+
+// isInterrupt returns true if the error was caused by cancellation of the
+// evaluation or by exceeding its limits. Such errors cannot be caught.
+func isInterrupt(err error) bool {
+	var limitErr *LimitError
+	return errors.As(err, &limitErr) ||
+		errors.Is(err, context.Canceled) ||
+		errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,207 @@
+package xlisp
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/spy16/sabre"
+)
+
+// Exception is the value thrown by throw and bound to the symbol of a catch
+// clause. Errors raised by Go functions are converted to Exception when they
+// are caught so that xlisp code deals with a single type.
+type Exception struct {
+	Message string
+	Data    sabre.Value
+	Cause   error
+}
+
+// Eval returns the exception itself.
+func (e *Exception) Eval(_ sabre.Scope) (sabre.Value, error) {
+	return e, nil
+}
+
+func (e *Exception) String() string {
+	if e.Data == nil {
+		return fmt.Sprintf("#error {:message %q}", e.Message)
+	}
+
+	return fmt.Sprintf("#error {:message %q :data %v}", e.Message, e.Data)
+}
+
+func (e *Exception) Error() string {
+	return e.Message
+}
+
+// Unwrap returns the underlying cause of the exception.
+func (e *Exception) Unwrap() error {
+	return e.Cause
+}
+
+// toException finds the exception carried by err. Errors that did not
+// originate from throw are wrapped into a new exception with the message of
+// the innermost evaluation error.
+func toException(err error) *Exception {
+	var exc *Exception
+	if errors.As(err, &exc) {
+		return exc
+	}
+
+	cause := err
+	for unwrapped := false; !unwrapped; {
+		switch e := cause.(type) {
+		case sabre.EvalError:
+			cause = e.Cause
+		case *TraceError:
+			cause = e.Cause
+		default:
+			unwrapped = true
+		}
+	}
+
+	return &Exception{Message: cause.Error(), Cause: err}
+}
+
+// ExInfo creates an exception carrying a message and a map of data and
+// optionally the cause of the exception.
+func ExInfo(msg string, data sabre.Value, cause ...error) (*Exception, error) {
+	if len(cause) > 1 {
+		return nil, fmt.Errorf("ex-info takes at most one cause, got %d", len(cause))
+	}
+
+	exc := &Exception{Message: msg}
+	switch data.(type) {
+	case sabre.Nil:
+	case *HashMap:
+		exc.Data = data
+	default:
+		return nil, fmt.Errorf("ex-info data must be a hash-map, not %s",
+			reflect.TypeOf(data))
+	}
+
+	if len(cause) == 1 {
+		exc.Cause = cause[0]
+	}
+
+	return exc, nil
+}
+
+// ExData returns the data attached to an exception created by ex-info or
+// nil for any other value.
+func ExData(v sabre.Value) sabre.Value {
+	exc, ok := v.(*Exception)
+	if !ok || exc.Data == nil {
+		return sabre.Nil{}
+	}
+
+	return exc.Data
+}
+
+// ExMessage returns the message of an exception or nil if the value is not
+// an exception.
+func ExMessage(v sabre.Value) sabre.Value {
+	exc, ok := v.(*Exception)
+	if !ok {
+		return sabre.Nil{}
+	}
+
+	return sabre.String(exc.Message)
+}
+
+// ExCause returns the exception that caused the given exception or nil.
+func ExCause(v sabre.Value) sabre.Value {
+	exc, ok := v.(*Exception)
+	if !ok || exc.Cause == nil {
+		return sabre.Nil{}
+	}
+
+	return toException(exc.Cause)
+}
+
+// parseTry implements the (try expr* (catch sym expr*)? (finally expr*)?)
+// special form. Errors raised while evaluating the body are bound to 'sym'
+// as an Exception within the catch clause, except the ones caused by
+// cancellation of the evaluation or by exceeding its limits. The finally
+// clause is always evaluated and its result is discarded.
+func parseTry(scope sabre.Scope, args []sabre.Value) (*sabre.Fn, error) {
+	var body, finally []sabre.Value
+	var catch *sabre.List
+	var catchSym sabre.Symbol
+
+	for i, form := range args {
+		clause, name := tryClause(form)
+
+		switch name {
+		case "catch":
+			if catch != nil {
+				return nil, fmt.Errorf("only one catch clause is allowed")
+			}
+
+			if len(clause.Values) < 2 {
+				return nil, fmt.Errorf("catch requires a binding symbol")
+			}
+
+			sym, isSymbol := clause.Values[1].(sabre.Symbol)
+			if !isSymbol {
+				return nil, fmt.Errorf(
+					"catch binding must be a symbol, not %s",
+					reflect.TypeOf(clause.Values[1]),
+				)
+			}
+
+			catch, catchSym = clause, sym
+
+		case "finally":
+			if i != len(args)-1 {
+				return nil, fmt.Errorf("finally clause must be the last form")
+			}
+			finally = clause.Values[1:]
+
+		default:
+			if catch != nil {
+				return nil, fmt.Errorf("body forms must appear before catch")
+			}
+			body = append(body, form)
+		}
+	}
+
+	return &sabre.Fn{
+		Func: func(scope sabre.Scope, _ []sabre.Value) (sabre.Value, error) {
+			result, err := sabre.Module(body).Eval(scope)
+			if err != nil && catch != nil && !isInterrupt(err) {
+				catchScope := newLocalScope(scope)
+				_ = catchScope.Bind(catchSym.Value, toException(err))
+				result, err = sabre.Module(catch.Values[2:]).Eval(catchScope)
+			}
+
+			if len(finally) > 0 {
+				if _, ferr := sabre.Module(finally).Eval(scope); ferr != nil {
+					return nil, ferr
+				}
+			}
+
+			if err != nil {
+				return nil, err
+			}
+
+			return result, nil
+		},
+	}, nil
+}
+
+// tryClause returns the list and the clause name if the form is a catch or
+// finally clause.
+func tryClause(form sabre.Value) (*sabre.List, string) {
+	list, isList := form.(*sabre.List)
+	if !isList || list.Size() == 0 {
+		return nil, ""
+	}
+
+	sym, isSymbol := list.First().(sabre.Symbol)
+	if !isSymbol || (sym.Value != "catch" && sym.Value != "finally") {
+		return nil, ""
+	}
+
+	return list, sym.Value
+}
@@ -0,0 +1,358 @@
+package xlisp
+
+import (
+	"fmt"
+
+	"github.com/spy16/sabre"
+)
+
+// Lambda defines an anonymous function using the same syntax as sabre's
+// fn* special form but produces Fn values which record stack frames.
+var Lambda = sabre.SpecialForm{
+	Name:  "fn*",
+	Parse: parseLambda,
+}
+
+// Macro defines a macro using the same syntax as sabre's macro* special
+// form. The parameters may be destructuring patterns.
+var Macro = sabre.SpecialForm{
+	Name:  "macro*",
+	Parse: parseMacro,
+}
+
+// Fn represents a multi-arity xlisp function. Errors raised while invoking
+// the function are annotated with a stack frame carrying the name of the
+// function and the position of the failing form in its body. Global symbols
+// in the body are resolved in the namespace the function was defined in.
+type Fn struct {
+	sabre.MultiFn
+	ns   string
+	meta *HashMap
+}
+
+// Eval returns the function itself.
+func (fn *Fn) Eval(_ sabre.Scope) (sabre.Value, error) {
+	return fn, nil
+}
+
+// Invoke evaluates the arguments, dispatches the call to a method based on
+// the number of arguments and handles tail recursion through recur.
+func (fn *Fn) Invoke(scope sabre.Scope, args ...sabre.Value) (sabre.Value, error) {
+	method, err := fn.selectMethod(args)
+	if err != nil {
+		return nil, err
+	}
+
+	argVals, err := evalValueList(scope, args)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := fn.call(scope, method, argVals)
+	for err == nil && isRecur(result) {
+		argVals = result.(*sabre.List).Values[1:]
+		result, err = fn.call(scope, method, argVals)
+	}
+
+	if err != nil {
+		return nil, addFrame(fn.frameName(), err)
+	}
+
+	return result, nil
+}
+
+// Compare returns true if 'v' is also a Fn with equivalent methods.
+func (fn *Fn) Compare(v sabre.Value) bool {
+	other, ok := v.(*Fn)
+	if !ok || other == nil {
+		return false
+	}
+
+	return fn.MultiFn.Compare(other.MultiFn)
+}
+
+// call binds the arguments in a new scope and evaluates the body of the
+// method in it.
+func (fn *Fn) call(scope sabre.Scope, method *sabre.Fn, args []sabre.Value) (sabre.Value, error) {
+	if method.Func != nil {
+		return method.Func(scope, args)
+	}
+
+	fnScope := newFnScope(scope, fn.ns)
+	if err := fnScope.check(); err != nil {
+		return nil, err
+	}
+
+	for idx, arg := range method.Args {
+		var argVal sabre.Value
+		if idx == len(method.Args)-1 && method.Variadic {
+			argVal = &sabre.List{Values: args[idx:]}
+		} else {
+			argVal = args[idx]
+		}
+
+		_ = fnScope.Bind(arg, argVal)
+	}
+
+	if method.Body == nil {
+		return sabre.Nil{}, nil
+	}
+
+	return sabre.Eval(fnScope, method.Body)
+}
+
+func (fn *Fn) selectMethod(args []sabre.Value) (*sabre.Fn, error) {
+	argc := len(args)
+	for i, method := range fn.Methods {
+		if method.Variadic && argc >= len(method.Args)-1 {
+			return &fn.Methods[i], nil
+		}
+
+		if !method.Variadic && argc == len(method.Args) {
+			return &fn.Methods[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("wrong number of args (%d) to '%s'",
+		argc, fn.frameName())
+}
+
+func (fn *Fn) frameName() string {
+	if fn.Name == "" {
+		return "fn"
+	}
+
+	return fn.Name
+}
+
+func parseLambda(scope sabre.Scope, forms []sabre.Value) (*sabre.Fn, error) {
+	if len(forms) > 0 {
+		var err error
+		if forms, err = expandLambda(forms); err != nil {
+			return nil, err
+		}
+	}
+
+	lambda, err := sabre.Lambda.Parse(scope, forms)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sabre.Fn{
+		Func: func(scope sabre.Scope, args []sabre.Value) (sabre.Value, error) {
+			v, err := lambda.Func(scope, args)
+			if err != nil {
+				return nil, err
+			}
+
+			return &Fn{
+				MultiFn: v.(sabre.MultiFn),
+				ns:      scopeNS(scope),
+			}, nil
+		},
+	}, nil
+}
+
+func parseMacro(scope sabre.Scope, forms []sabre.Value) (*sabre.Fn, error) {
+	if len(forms) > 0 {
+		var err error
+		if forms, err = expandLambda(forms); err != nil {
+			return nil, err
+		}
+	}
+
+	macro, err := sabre.Macro.Parse(scope, forms)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sabre.Fn{
+		Func: func(scope sabre.Scope, args []sabre.Value) (sabre.Value, error) {
+			v, err := macro.Func(scope, args)
+			if err != nil {
+				return nil, err
+			}
+
+			mfn := v.(sabre.MultiFn)
+			methods := make([]sabre.Fn, len(mfn.Methods))
+			for i, method := range mfn.Methods {
+				methods[i] = checkedExpansion(method)
+			}
+			mfn.Methods = methods
+
+			return mfn, nil
+		},
+	}, nil
+}
+
+// checkedExpansion wraps the macro method so that its expansions are
+// checked against the capabilities of the interpreter like the forms given
+// to eval, since they may contain symbols built at runtime.
+func checkedExpansion(method sabre.Fn) sabre.Fn {
+	expand := method
+	method.Func = func(scope sabre.Scope, args []sabre.Value) (sabre.Value, error) {
+		form, err := expand.Invoke(scope, args...)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := checkForm(scope, form); err != nil {
+			return nil, err
+		}
+
+		return form, nil
+	}
+
+	return method
+}
+
+// anonFnReader returns the reader macro for anonymous function literals.
+// Each reader gets its own so that it can reject nested literals, in which
+// % would be ambiguous.
+func anonFnReader() sabre.ReaderMacro {
+	var reading bool
+	return func(rd *sabre.Reader, r rune) (sabre.Value, error) {
+		if reading {
+			return nil, fmt.Errorf("nested #()s are not allowed")
+		}
+
+		reading = true
+		defer func() { reading = false }()
+
+		return readAnonFn(rd, r)
+	}
+}
+
+// readAnonFn reads an anonymous function literal such as #(+ % 1) as
+// (fn* [%1] (+ %1 1)). The arguments are referred to as %1, %2 and so on,
+// % being the same as %1, and %& refers to the rest of the arguments.
+func readAnonFn(rd *sabre.Reader, _ rune) (sabre.Value, error) {
+	pos := rd.Position()
+
+	forms, err := readContainer(rd, ')', "anonymous function")
+	if err != nil {
+		return nil, err
+	}
+
+	params := &anonFnParams{}
+	body, err := params.rewrite(&sabre.List{Values: forms, Position: pos})
+	if err != nil {
+		return nil, err
+	}
+
+	var args []sabre.Value
+	for i := 1; i <= params.max; i++ {
+		args = append(args, sabre.Symbol{Value: fmt.Sprintf("%%%d", i)})
+	}
+
+	if params.rest {
+		args = append(args, sabre.Symbol{Value: "&"}, sabre.Symbol{Value: "%&"})
+	}
+
+	return &sabre.List{
+		Values: []sabre.Value{
+			sabre.Symbol{Value: "fn*"}, NewVector(args...), body,
+		},
+		Position: pos,
+	}, nil
+}
+
+// anonFnParams records the arguments referred to in the body of an
+// anonymous function literal.
+type anonFnParams struct {
+	max  int
+	rest bool
+}
+
+// rewrite returns the form with % replaced by %1 and records the
+// arguments used in it.
+func (p *anonFnParams) rewrite(form sabre.Value) (sabre.Value, error) {
+	switch f := form.(type) {
+	case sabre.Symbol:
+		return p.param(f)
+
+	case *sabre.List:
+		vals, err := p.rewriteAll(f.Values)
+		if err != nil {
+			return nil, err
+		}
+		return &sabre.List{Values: vals, Position: f.Position}, nil
+
+	case *Vector:
+		vals, err := p.rewriteAll(f.Values())
+		if err != nil {
+			return nil, err
+		}
+
+		vec := NewVector(vals...)
+		vec.Position = f.Position
+		return vec, nil
+
+	case *HashMap:
+		var kvs []sabre.Value
+		for _, key := range f.Keys() {
+			val, _ := f.Lookup(key)
+			kvs = append(kvs, key, val)
+		}
+
+		kvs, err := p.rewriteAll(kvs)
+		if err != nil {
+			return nil, err
+		}
+
+		hm := NewHashMap(kvs...)
+		hm.Position = f.Position
+		return hm, nil
+
+	case *Set:
+		vals, err := p.rewriteAll(f.Values())
+		if err != nil {
+			return nil, err
+		}
+
+		set := NewSet(vals...)
+		set.Position = f.Position
+		return set, nil
+	}
+
+	return form, nil
+}
+
+func (p *anonFnParams) rewriteAll(forms []sabre.Value) ([]sabre.Value, error) {
+	res := make([]sabre.Value, len(forms))
+	for i, form := range forms {
+		var err error
+		if res[i], err = p.rewrite(form); err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+func (p *anonFnParams) param(sym sabre.Symbol) (sabre.Value, error) {
+	name := sym.Value
+	switch {
+	case name == "%":
+		name = "%1"
+
+	case name == "%&":
+		p.rest = true
+		return sym, nil
+
+	case len(name) < 2 || name[0] != '%':
+		return sym, nil
+	}
+
+	var n int
+	if _, err := fmt.Sscanf(name, "%%%d", &n); err != nil ||
+		fmt.Sprintf("%%%d", n) != name || n < 1 {
+		return nil, fmt.Errorf("invalid anonymous function argument: %s", sym.Value)
+	}
+
+	if n > p.max {
+		p.max = n
+	}
+
+	return sabre.Symbol{Value: name, Position: sym.Position}, nil
+}
@@ -1,6 +1,6 @@
 module github.com/issadarkthing/xlisp
 
-go 1.13
+go 1.16
 
 require (
 	github.com/chzyer/logex v1.1.10 // indirect
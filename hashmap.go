@@ -0,0 +1,814 @@
+package xlisp
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"reflect"
+
+	"github.com/spy16/sabre"
+)
+
+// HashMap is a persistent hash map implemented as a hash array mapped trie.
+// Each node of the trie holds the entries and sub-tries for 5 bits of the
+// key hash, so Assoc and Dissoc copy at most one node per level and share
+// the rest of the trie with the original map.
+//
+// Keys are compared using equal, hence any value can be used as a key.
+// Symbols are compared by name ignoring their position and integers are
+// equal regardless of being an Int64 or a BigInt. References such as atoms
+// are compared by identity. Go values used as keys may implement Hashable.
+type HashMap struct {
+	sabre.Position
+
+	meta *HashMap
+	root *hamtNode
+	cnt  int
+}
+
+// NewHashMap returns a map holding the given keys and values, which must be
+// given as alternating key value pairs.
+func NewHashMap(kvs ...sabre.Value) *HashMap {
+	t := (&HashMap{}).Transient()
+	for i := 0; i+1 < len(kvs); i += 2 {
+		t.assoc(kvs[i], kvs[i+1])
+	}
+	return t.persistent()
+}
+
+// Eval evaluates all keys and values and returns a new map containing the
+// evaluated values.
+func (hm *HashMap) Eval(scope sabre.Scope) (sabre.Value, error) {
+	t := (&HashMap{}).Transient()
+
+	var err error
+	hm.each(func(k, v sabre.Value) bool {
+		var key, val sabre.Value
+		if key, err = sabre.Eval(scope, k); err != nil {
+			return false
+		}
+
+		if val, err = sabre.Eval(scope, v); err != nil {
+			return false
+		}
+
+		t.assoc(key, val)
+		return true
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	res := t.persistent()
+	res.Position = hm.Position
+	res.meta = hm.meta
+	return res, nil
+}
+
+// Invoke of a map returns the value associated with the key or the
+// default value, which is nil if not given.
+func (hm *HashMap) Invoke(scope sabre.Scope, args ...sabre.Value) (sabre.Value, error) {
+	if err := verifyArgCount([]int{1, 2}, args); err != nil {
+		return nil, err
+	}
+
+	vals, err := evalValueList(scope, args)
+	if err != nil {
+		return nil, err
+	}
+
+	def := sabre.Value(sabre.Nil{})
+	if len(vals) == 2 {
+		def = vals[1]
+	}
+
+	return hm.Get(vals[0], def), nil
+}
+
+// Size returns the number of entries in the map.
+func (hm *HashMap) Size() int { return hm.cnt }
+
+// Get returns the value associated with the key if found. Returns def
+// otherwise.
+func (hm *HashMap) Get(key, def sabre.Value) sabre.Value {
+	if v, found := hm.Lookup(key); found {
+		return v
+	}
+	return def
+}
+
+// Lookup returns the value associated with the key and whether the key was
+// found.
+func (hm *HashMap) Lookup(key sabre.Value) (sabre.Value, bool) {
+	if hm.root == nil {
+		return nil, false
+	}
+
+	return hm.root.get(0, hashValue(key), key)
+}
+
+// Contains returns true if the map has an entry for the key.
+func (hm *HashMap) Contains(key sabre.Value) bool {
+	_, found := hm.Lookup(key)
+	return found
+}
+
+// Assoc returns a new map with the key associated with the value.
+func (hm *HashMap) Assoc(key, val sabre.Value) *HashMap {
+	root := hm.root
+	if root == nil {
+		root = &hamtNode{}
+	}
+
+	root, added := root.assoc(nil, 0, hashValue(key), key, val)
+
+	res := &HashMap{Position: hm.Position, meta: hm.meta, root: root, cnt: hm.cnt}
+	if added {
+		res.cnt++
+	}
+	return res
+}
+
+// Dissoc returns a new map without the entry for the key.
+func (hm *HashMap) Dissoc(key sabre.Value) *HashMap {
+	if hm.root == nil {
+		return hm
+	}
+
+	root, removed := hm.root.dissoc(nil, 0, hashValue(key), key)
+	if !removed {
+		return hm
+	}
+
+	return &HashMap{Position: hm.Position, meta: hm.meta, root: root, cnt: hm.cnt - 1}
+}
+
+// Keys returns all the keys in the map.
+func (hm *HashMap) Keys() []sabre.Value {
+	keys := make([]sabre.Value, 0, hm.cnt)
+	hm.each(func(k, _ sabre.Value) bool {
+		keys = append(keys, k)
+		return true
+	})
+	return keys
+}
+
+// Vals returns all the values in the map in the order of Keys.
+func (hm *HashMap) Vals() []sabre.Value {
+	vals := make([]sabre.Value, 0, hm.cnt)
+	hm.each(func(_, v sabre.Value) bool {
+		vals = append(vals, v)
+		return true
+	})
+	return vals
+}
+
+// First returns the first entry of the map as a [key value] vector or nil
+// if the map is empty.
+func (hm *HashMap) First() sabre.Value {
+	if hm.cnt == 0 {
+		return nil
+	}
+
+	e := hm.root.first()
+	return NewVector(e.key, e.val)
+}
+
+// Next returns a sequence of the entries after the first one.
+func (hm *HashMap) Next() sabre.Seq {
+	if hm.cnt <= 1 {
+		return nil
+	}
+
+	entries := make([]sabre.Value, 0, hm.cnt)
+	hm.each(func(k, v sabre.Value) bool {
+		entries = append(entries, NewVector(k, v))
+		return true
+	})
+
+	return &sabre.List{Values: entries[1:]}
+}
+
+// Cons returns a sequence with the value prepended to the entries of the
+// map.
+func (hm *HashMap) Cons(v sabre.Value) sabre.Seq {
+	return &Cons{first: v, rest: hm}
+}
+
+// Conj returns a new map with the given [key value] entries or the entries
+// of the given maps added. Other values are ignored since Conj cannot fail,
+// core/conj reports them as errors instead.
+func (hm *HashMap) Conj(vals ...sabre.Value) sabre.Seq {
+	t := hm.Transient()
+	for _, v := range vals {
+		switch entry := v.(type) {
+		case *Vector:
+			if entry.Size() == 2 {
+				t.assoc(entry.Nth(0), entry.Nth(1))
+			}
+
+		case *HashMap:
+			entry.each(func(k, v sabre.Value) bool {
+				t.assoc(k, v)
+				return true
+			})
+		}
+	}
+
+	res := t.persistent()
+	res.Position = hm.Position
+	res.meta = hm.meta
+	return res
+}
+
+// Compare returns true if the other value is a map with equal entries.
+func (hm *HashMap) Compare(other sabre.Value) bool {
+	o, ok := other.(*HashMap)
+	if !ok || o.cnt != hm.cnt {
+		return false
+	}
+
+	same := true
+	hm.each(func(k, v sabre.Value) bool {
+		ov, found := o.Lookup(k)
+		same = found && equal(v, ov)
+		return same
+	})
+	return same
+}
+
+func (hm *HashMap) String() string {
+	fields := make([]sabre.Value, 0, 2*hm.cnt)
+	hm.each(func(k, v sabre.Value) bool {
+		fields = append(fields, k, v)
+		return true
+	})
+	return containerString(fields, "{", "}")
+}
+
+// each calls fn with every entry of the map until fn returns false.
+func (hm *HashMap) each(fn func(k, v sabre.Value) bool) {
+	if hm.root != nil {
+		hm.root.each(fn)
+	}
+}
+
+// Set is a persistent set backed by a HashMap mapping each value to
+// itself.
+type Set struct {
+	sabre.Position
+
+	meta *HashMap
+	m    *HashMap
+}
+
+// NewSet returns a set holding the given values.
+func NewSet(vals ...sabre.Value) *Set {
+	t := (&HashMap{}).Transient()
+	for _, v := range vals {
+		t.assoc(v, v)
+	}
+	return &Set{m: t.persistent()}
+}
+
+// Eval evaluates the values of the set and returns a new set of the
+// results.
+func (set *Set) Eval(scope sabre.Scope) (sabre.Value, error) {
+	t := (&HashMap{}).Transient()
+	for _, v := range set.m.Keys() {
+		val, err := sabre.Eval(scope, v)
+		if err != nil {
+			return nil, err
+		}
+		t.assoc(val, val)
+	}
+
+	return &Set{Position: set.Position, meta: set.meta, m: t.persistent()}, nil
+}
+
+// Invoke of a set returns the argument if it is a member of the set or nil
+// otherwise.
+func (set *Set) Invoke(scope sabre.Scope, args ...sabre.Value) (sabre.Value, error) {
+	vals, err := evalValueList(scope, args)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(vals) != 1 {
+		return nil, fmt.Errorf("call requires exactly 1 argument, got %d", len(vals))
+	}
+
+	return set.m.Get(vals[0], sabre.Nil{}), nil
+}
+
+// Size returns the number of values in the set.
+func (set *Set) Size() int { return set.m.cnt }
+
+// Contains returns true if the value is a member of the set.
+func (set *Set) Contains(v sabre.Value) bool { return set.m.Contains(v) }
+
+// Disj returns a new set without the value.
+func (set *Set) Disj(v sabre.Value) *Set {
+	return &Set{Position: set.Position, meta: set.meta, m: set.m.Dissoc(v)}
+}
+
+// Values returns the members of the set.
+func (set *Set) Values() []sabre.Value { return set.m.Keys() }
+
+// First returns a member of the set or nil if it is empty.
+func (set *Set) First() sabre.Value {
+	if set.m.cnt == 0 {
+		return nil
+	}
+
+	return set.m.root.first().key
+}
+
+// Next returns a sequence of the members other than the one returned by
+// First.
+func (set *Set) Next() sabre.Seq {
+	if set.m.cnt <= 1 {
+		return nil
+	}
+
+	return &sabre.List{Values: set.m.Keys()[1:]}
+}
+
+// Cons returns a sequence with the value prepended to the members of the
+// set.
+func (set *Set) Cons(v sabre.Value) sabre.Seq {
+	return &Cons{first: v, rest: set}
+}
+
+// Conj returns a new set with the values added.
+func (set *Set) Conj(vals ...sabre.Value) sabre.Seq {
+	if len(vals) == 1 {
+		return set.conj(vals[0])
+	}
+
+	t := set.m.Transient()
+	for _, v := range vals {
+		t.assoc(v, v)
+	}
+	return &Set{Position: set.Position, meta: set.meta, m: t.persistent()}
+}
+
+// Compare returns true if the other value is a set with the same members.
+func (set *Set) Compare(other sabre.Value) bool {
+	o, ok := other.(*Set)
+	if !ok || o.m.cnt != set.m.cnt {
+		return false
+	}
+
+	equal := true
+	set.m.each(func(k, _ sabre.Value) bool {
+		equal = o.m.Contains(k)
+		return equal
+	})
+	return equal
+}
+
+func (set *Set) String() string {
+	return containerString(set.m.Keys(), "#{", "}")
+}
+
+func (set *Set) conj(v sabre.Value) *Set {
+	return &Set{Position: set.Position, meta: set.meta, m: set.m.Assoc(v, v)}
+}
+
+const (
+	hamtBits = 5
+	hamtMask = 1<<hamtBits - 1
+)
+
+// hamtNode is a node of the trie. The datamap records which of the 32
+// possible hash fragments at the level of the node hold an entry and the
+// nodemap which hold a sub-trie. Keeping the sub-tries apart from the
+// entries means updating a deep entry only copies the short slices of
+// child pointers along its path. Entries whose keys have the same hash are
+// kept in a collision node which is searched linearly.
+//
+// Nodes owned by a transient map carry its edit token and are modified in
+// place by the transient.
+type hamtNode struct {
+	edit      *editToken
+	datamap   uint32
+	nodemap   uint32
+	entries   []hamtEntry
+	children  []*hamtNode
+	collision bool
+}
+
+type hamtEntry struct {
+	hash uint32
+	key  sabre.Value
+	val  sabre.Value
+}
+
+func bitIndex(bitmap, bit uint32) int {
+	return bits.OnesCount32(bitmap & (bit - 1))
+}
+
+// editable returns the node if it is owned by the edit token or a copy of
+// the node owned by the token otherwise. A nil token always copies. Copies
+// made for a token own their slices since the transient updates them in
+// place, while persistent copies share the slices with the node until one
+// of the set, insert or remove methods replaces them.
+func (n *hamtNode) editable(edit *editToken) *hamtNode {
+	if edit != nil && n.edit == edit {
+		return n
+	}
+
+	res := *n
+	res.edit = edit
+	if edit != nil {
+		res.entries = append([]hamtEntry(nil), n.entries...)
+		res.children = append([]*hamtNode(nil), n.children...)
+	}
+	return &res
+}
+
+func (n *hamtNode) setEntry(i int, e hamtEntry) {
+	if n.edit == nil {
+		n.entries = append([]hamtEntry(nil), n.entries...)
+	}
+	n.entries[i] = e
+}
+
+func (n *hamtNode) insertEntry(i int, e hamtEntry) {
+	if n.edit == nil {
+		entries := make([]hamtEntry, len(n.entries)+1)
+		copy(entries, n.entries[:i])
+		copy(entries[i+1:], n.entries[i:])
+		entries[i] = e
+		n.entries = entries
+		return
+	}
+
+	n.entries = append(n.entries, hamtEntry{})
+	copy(n.entries[i+1:], n.entries[i:])
+	n.entries[i] = e
+}
+
+func (n *hamtNode) removeEntry(i int) {
+	if n.edit == nil {
+		entries := make([]hamtEntry, len(n.entries)-1)
+		copy(entries, n.entries[:i])
+		copy(entries[i:], n.entries[i+1:])
+		n.entries = entries
+		return
+	}
+
+	last := len(n.entries) - 1
+	copy(n.entries[i:], n.entries[i+1:])
+	n.entries[last] = hamtEntry{}
+	n.entries = n.entries[:last]
+}
+
+func (n *hamtNode) setChild(i int, child *hamtNode) {
+	if n.children[i] == child {
+		return
+	}
+	if n.edit == nil {
+		n.children = append([]*hamtNode(nil), n.children...)
+	}
+	n.children[i] = child
+}
+
+func (n *hamtNode) insertChild(i int, child *hamtNode) {
+	if n.edit == nil {
+		children := make([]*hamtNode, len(n.children)+1)
+		copy(children, n.children[:i])
+		copy(children[i+1:], n.children[i:])
+		children[i] = child
+		n.children = children
+		return
+	}
+
+	n.children = append(n.children, nil)
+	copy(n.children[i+1:], n.children[i:])
+	n.children[i] = child
+}
+
+func (n *hamtNode) removeChild(i int) {
+	if n.edit == nil {
+		children := make([]*hamtNode, len(n.children)-1)
+		copy(children, n.children[:i])
+		copy(children[i:], n.children[i+1:])
+		n.children = children
+		return
+	}
+
+	last := len(n.children) - 1
+	copy(n.children[i:], n.children[i+1:])
+	n.children[last] = nil
+	n.children = n.children[:last]
+}
+
+func (n *hamtNode) get(shift uint, hash uint32, key sabre.Value) (sabre.Value, bool) {
+	if n.collision {
+		for _, e := range n.entries {
+			if equal(e.key, key) {
+				return e.val, true
+			}
+		}
+		return nil, false
+	}
+
+	bit := uint32(1) << ((hash >> shift) & hamtMask)
+	switch {
+	case n.datamap&bit != 0:
+		e := n.entries[bitIndex(n.datamap, bit)]
+		if e.hash == hash && equal(e.key, key) {
+			return e.val, true
+		}
+
+	case n.nodemap&bit != 0:
+		return n.children[bitIndex(n.nodemap, bit)].get(shift+hamtBits, hash, key)
+	}
+
+	return nil, false
+}
+
+// assoc returns the node with the key associated with the value and
+// whether a new entry was added. Nodes owned by the edit token are updated
+// in place.
+func (n *hamtNode) assoc(edit *editToken, shift uint, hash uint32, key, val sabre.Value) (*hamtNode, bool) {
+	entry := hamtEntry{hash: hash, key: key, val: val}
+
+	if n.collision {
+		res := n.editable(edit)
+		for i, e := range n.entries {
+			if equal(e.key, key) {
+				res.setEntry(i, entry)
+				return res, false
+			}
+		}
+
+		res.insertEntry(len(n.entries), entry)
+		return res, true
+	}
+
+	bit := uint32(1) << ((hash >> shift) & hamtMask)
+
+	if n.nodemap&bit != 0 {
+		idx := bitIndex(n.nodemap, bit)
+		child, added := n.children[idx].assoc(edit, shift+hamtBits, hash, key, val)
+		res := n.editable(edit)
+		res.setChild(idx, child)
+		return res, added
+	}
+
+	idx := bitIndex(n.datamap, bit)
+	if n.datamap&bit == 0 {
+		res := n.editable(edit)
+		res.datamap |= bit
+		res.insertEntry(idx, entry)
+		return res, true
+	}
+
+	res := n.editable(edit)
+	e := n.entries[idx]
+	if e.hash == hash && equal(e.key, key) {
+		res.setEntry(idx, entry)
+		return res, false
+	}
+
+	res.datamap &^= bit
+	res.nodemap |= bit
+	res.removeEntry(idx)
+	res.insertChild(bitIndex(n.nodemap, bit), newHamtPair(edit, shift+hamtBits, e, entry))
+	return res, true
+}
+
+// newHamtPair returns a node holding the two entries whose hashes have the
+// same fragments up to the given shift.
+func newHamtPair(edit *editToken, shift uint, a, b hamtEntry) *hamtNode {
+	if a.hash == b.hash {
+		return &hamtNode{edit: edit, entries: []hamtEntry{a, b}, collision: true}
+	}
+
+	bitA := uint32(1) << ((a.hash >> shift) & hamtMask)
+	bitB := uint32(1) << ((b.hash >> shift) & hamtMask)
+	switch {
+	case bitA == bitB:
+		return &hamtNode{
+			edit:     edit,
+			nodemap:  bitA,
+			children: []*hamtNode{newHamtPair(edit, shift+hamtBits, a, b)},
+		}
+
+	case bitB < bitA:
+		a, b = b, a
+	}
+
+	return &hamtNode{edit: edit, datamap: bitA | bitB, entries: []hamtEntry{a, b}}
+}
+
+// dissoc returns the node without the entry for the key, or nil if the
+// node becomes empty, and whether an entry was removed. Nodes owned by the
+// edit token are updated in place.
+func (n *hamtNode) dissoc(edit *editToken, shift uint, hash uint32, key sabre.Value) (*hamtNode, bool) {
+	if n.collision {
+		for i, e := range n.entries {
+			if equal(e.key, key) {
+				if len(n.entries) == 1 {
+					return nil, true
+				}
+				res := n.editable(edit)
+				res.removeEntry(i)
+				return res, true
+			}
+		}
+		return n, false
+	}
+
+	bit := uint32(1) << ((hash >> shift) & hamtMask)
+
+	if n.datamap&bit != 0 {
+		idx := bitIndex(n.datamap, bit)
+		e := n.entries[idx]
+		if e.hash != hash || !equal(e.key, key) {
+			return n, false
+		}
+		if len(n.entries) == 1 && len(n.children) == 0 {
+			return nil, true
+		}
+
+		res := n.editable(edit)
+		res.datamap &^= bit
+		res.removeEntry(idx)
+		return res, true
+	}
+
+	if n.nodemap&bit == 0 {
+		return n, false
+	}
+
+	idx := bitIndex(n.nodemap, bit)
+	child, removed := n.children[idx].dissoc(edit, shift+hamtBits, hash, key)
+	if !removed {
+		return n, false
+	}
+
+	switch {
+	case child == nil && len(n.children) == 1 && len(n.entries) == 0:
+		return nil, true
+
+	case child == nil:
+		res := n.editable(edit)
+		res.nodemap &^= bit
+		res.removeChild(idx)
+		return res, true
+
+	case len(child.entries) == 1 && len(child.children) == 0:
+		// pull single entries up so that lookups stay short.
+		res := n.editable(edit)
+		res.nodemap &^= bit
+		res.removeChild(idx)
+		res.datamap |= bit
+		res.insertEntry(bitIndex(res.datamap, bit), child.entries[0])
+		return res, true
+	}
+
+	res := n.editable(edit)
+	res.setChild(idx, child)
+	return res, true
+}
+
+func (n *hamtNode) first() hamtEntry {
+	if len(n.entries) > 0 {
+		return n.entries[0]
+	}
+	return n.children[0].first()
+}
+
+func (n *hamtNode) each(fn func(k, v sabre.Value) bool) bool {
+	for _, e := range n.entries {
+		if !fn(e.key, e.val) {
+			return false
+		}
+	}
+	for _, child := range n.children {
+		if !child.each(fn) {
+			return false
+		}
+	}
+	return true
+}
+
+// Hashable is implemented by values which define their own hash, used as
+// keys of maps and values of sets. Values which are equal must have the
+// same hash.
+type Hashable interface {
+	Hash() uint32
+}
+
+// hashValue returns the hash of the value. Values equal according to
+// equal have the same hash.
+func hashValue(v sabre.Value) uint32 {
+	switch val := v.(type) {
+	case nil, sabre.Nil:
+		return 0
+
+	case Hashable:
+		return val.Hash()
+
+	case sabre.Bool:
+		if val {
+			return 1231
+		}
+		return 1237
+
+	case sabre.Int64:
+		return hashUint64(uint64(val))
+
+	case BigInt:
+		if val.i.IsInt64() {
+			return hashUint64(uint64(val.i.Int64()))
+		}
+		return hashString(val.i.String())
+
+	case Ratio:
+		return hashString(val.String())
+
+	case BigDecimal:
+		return hashString(val.Rat().String() + "M")
+
+	case sabre.Float64:
+		return hashUint64(math.Float64bits(float64(val)))
+
+	case sabre.Character:
+		return hashUint64(uint64(val))
+
+	case sabre.String:
+		return hashString("\"" + string(val))
+
+	case Keyword:
+		return hashString(":" + string(val))
+
+	case sabre.Symbol:
+		return hashString("'" + val.Value)
+
+	case *HashMap:
+		var h uint32
+		val.each(func(k, v sabre.Value) bool {
+			h += hashValue(k) ^ hashValue(v)
+			return true
+		})
+		return h
+
+	case *Set:
+		var h uint32
+		val.m.each(func(k, _ sabre.Value) bool {
+			h += hashValue(k)
+			return true
+		})
+		return h
+
+	case sabre.Seq:
+		h := uint32(1)
+		_ = forEach(val, func(item sabre.Value) error {
+			h = 31*h + hashValue(item)
+			return nil
+		})
+		return h
+	}
+
+	if id, ok := identityOf(v); ok {
+		return hashUint64(uint64(id))
+	}
+
+	// values of other types are equal if they have the same type and
+	// contents, hence the same string.
+	return hashString(reflect.TypeOf(v).String() + " " + v.String())
+}
+
+// identityOf returns the address of the value if it is a reference, such
+// as an atom or a Go pointer, which does not define its own equality.
+// Such values are only equal to themselves.
+func identityOf(v sabre.Value) (uintptr, bool) {
+	if _, ok := v.(interface{ Compare(sabre.Value) bool }); ok {
+		return 0, false
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		return rv.Pointer(), true
+	}
+
+	return 0, false
+}
+
+func hashString(s string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(s))
+	return h.Sum32()
+}
+
+func hashUint64(n uint64) uint32 {
+	return uint32(n ^ n>>32)
+}
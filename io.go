@@ -1,7 +1,6 @@
 package xlisp
 
 import (
-	"bufio"
 	"bytes"
 	"fmt"
 	"io/ioutil"
@@ -9,48 +8,124 @@ import (
 	"os"
 	"os/exec"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/spy16/sabre"
 )
 
-// Println is an alias for fmt.Println which ignores the return values.
-func Println(args ...interface{}) error {
-	_, err := fmt.Println(args...)
+// Println writes the args separated by spaces and followed by a newline to
+// the stream bound to *out*.
+func Println(scope sabre.Scope, args ...interface{}) error {
+	out, err := stdout(scope)
+	if err != nil {
+		return err
+	}
+
+	if err := realizeArgs(args); err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(out, args...)
 	return err
 }
 
-// Printf is an alias for fmt.Printf which ignores the return values.
-func Printf(format string, args ...interface{}) error {
-	_, err := fmt.Printf(format, args...)
+// Printf writes the formatted string to the stream bound to *out*.
+func Printf(scope sabre.Scope, format string, args ...interface{}) error {
+	out, err := stdout(scope)
+	if err != nil {
+		return err
+	}
+
+	if err := realizeArgs(args); err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(out, format, args...)
 	return err
 }
 
-// Reads from stdin and returns string
-func Read(prompt string) (string, error) {
+// Eprintln writes the args separated by spaces and followed by a newline to
+// the stream bound to *err*.
+func Eprintln(scope sabre.Scope, args ...interface{}) error {
+	w, err := stderr(scope)
+	if err != nil {
+		return err
+	}
+
+	if err := realizeArgs(args); err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(w, args...)
+	return err
+}
 
-	reader := bufio.NewReader(os.Stdin)
-	fmt.Print(prompt)
-	text, err := reader.ReadString('\n')
+// Eprintf writes the formatted string to the stream bound to *err*.
+func Eprintf(scope sabre.Scope, format string, args ...interface{}) error {
+	w, err := stderr(scope)
+	if err != nil {
+		return err
+	}
+
+	if err := realizeArgs(args); err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, format, args...)
+	return err
+}
+
+// realizeArgs realizes the lazy sequences in the arguments of the print
+// functions, see RealizeAll.
+func realizeArgs(args []interface{}) error {
+	for _, arg := range args {
+		if v, ok := arg.(sabre.Value); ok {
+			if err := RealizeAll(v); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Read writes the prompt to the stream bound to *out* and returns a line
+// read from the stream bound to *in*.
+func Read(scope sabre.Scope, prompt string) (string, error) {
+	in, err := stdin(scope)
 	if err != nil {
 		return "", err
 	}
 
-	return text[:len(text)-1], nil
+	if prompt != "" {
+		if err := Printf(scope, "%s", prompt); err != nil {
+			return "", err
+		}
+	}
+
+	return in.ReadLine()
 }
 
-func Random(max int) int {
-	rand.Seed(time.Now().UnixNano())
-	result := rand.Intn(max)
+// Random returns a random integer in [0, max) using the random source of
+// the interpreter.
+func Random(scope sabre.Scope, max int) int {
+	var result int
+	withRand(scope, func(rnd *rand.Rand) {
+		result = rnd.Intn(max)
+	})
 	return result
 }
 
-func Shuffle(seq sabre.Seq) sabre.Seq {
-	rand.Seed(time.Now().UnixNano())
+// Shuffle returns the values of the sequence in random order using the
+// random source of the interpreter.
+func Shuffle(scope sabre.Scope, seq sabre.Seq) sabre.Seq {
 	list := Realize(seq)
 	values := list.Values
-	rand.Shuffle(len(list.Values), func(i, j int) {
-		values[i], values[j] = values[j], values[i]
+	withRand(scope, func(rnd *rand.Rand) {
+		rnd.Shuffle(len(list.Values), func(i, j int) {
+			values[i], values[j] = values[j], values[i]
+		})
 	})
 	return list
 }
@@ -65,17 +140,41 @@ func ReadFile(name string) (string, error) {
 	return string(content), nil
 }
 
-func createShellOutput(out, err string, exit int) *sabre.HashMap {
-	return &sabre.HashMap{
-		Data: map[sabre.Value]sabre.Value{
-			sabre.Keyword("exit"): sabre.Int64(exit),
-			sabre.Keyword("out"):  sabre.String(out),
-			sabre.Keyword("err"):  sabre.String(err),
-		},
+// LoadFile reads and evaluates all the forms in the named file. Errors are
+// annotated with a frame pointing to the failing top-level form in the file.
+func LoadFile(scope sabre.Scope, name string) (sabre.Value, error) {
+	fh, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	mod, err := NewReader(fh).All()
+	if err != nil {
+		return nil, err
 	}
+
+	if err := checkForm(scope, mod); err != nil {
+		return nil, err
+	}
+
+	res, err := sabre.Eval(scope, mod)
+	if err != nil {
+		return nil, addFrame("", err)
+	}
+
+	return res, nil
 }
 
-func Shell(command string) (*sabre.HashMap, error) {
+func createShellOutput(out, err string, exit int) *HashMap {
+	return NewHashMap(
+		Keyword("exit"), sabre.Int64(exit),
+		Keyword("out"), sabre.String(out),
+		Keyword("err"), sabre.String(err),
+	)
+}
+
+func Shell(command string) (*HashMap, error) {
 
 	cmd := exec.Command("bash", "-c", command)
 	var cmdout, cmderr bytes.Buffer
@@ -88,7 +187,7 @@ func Shell(command string) (*sabre.HashMap, error) {
 		errMsg := strings.TrimSpace(cmderr.String())
 		return createShellOutput("", errMsg, exitErr.ExitCode()), nil
 	} else if err != nil {
-		return &sabre.HashMap{}, err
+		return NewHashMap(), err
 	}
 
 	output := strings.TrimSpace(cmdout.String())
@@ -96,11 +195,22 @@ func Shell(command string) (*sabre.HashMap, error) {
 	return createShellOutput(output, "", 0), nil
 }
 
-func splitString(str, sep sabre.String) *sabre.List {
-	result := strings.Split(string(str), string(sep))
-	values := make([]sabre.Value, 0, len(result))
-	for _, v := range result {
-		values = append(values, sabre.String(v))
+var (
+	defaultRandMu sync.Mutex
+	defaultRand   = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// withRand calls fn with the random source of the interpreter. Sources are
+// not safe for concurrent use, hence calls are serialized.
+func withRand(scope sabre.Scope, fn func(rnd *rand.Rand)) {
+	if xl := interpreter(scope); xl != nil && xl.random != nil {
+		xl.randMu.Lock()
+		defer xl.randMu.Unlock()
+		fn(xl.random)
+		return
 	}
-	return &sabre.List{Values: values}
+
+	defaultRandMu.Lock()
+	defer defaultRandMu.Unlock()
+	fn(defaultRand)
 }
@@ -0,0 +1,66 @@
+package xlisp
+
+import (
+	"io"
+	"strings"
+
+	"github.com/spy16/sabre"
+)
+
+// Keyword represents a keyword literal such as :name. Invoking a keyword
+// with a collection looks the keyword up in it like get, e.g.
+// (:exit ($ "true")) or (map :name people).
+type Keyword string
+
+// Eval returns the keyword itself.
+func (kw Keyword) Eval(_ sabre.Scope) (sabre.Value, error) { return kw, nil }
+
+func (kw Keyword) String() string { return ":" + string(kw) }
+
+// CompareTo compares the keyword with another keyword by name.
+func (kw Keyword) CompareTo(other sabre.Value) (int, error) {
+	o, ok := other.(Keyword)
+	if !ok {
+		return 0, notComparable(kw, other)
+	}
+
+	return strings.Compare(string(kw), string(o)), nil
+}
+
+// Invoke returns the value associated with the keyword in the collection
+// given as the first argument or the default value given as the second.
+func (kw Keyword) Invoke(scope sabre.Scope, args ...sabre.Value) (sabre.Value, error) {
+	if err := verifyArgCount([]int{1, 2}, args); err != nil {
+		return nil, err
+	}
+
+	vals, err := evalValueList(scope, args)
+	if err != nil {
+		return nil, err
+	}
+
+	return get(vals[0], kw, vals[1:]...)
+}
+
+// readKeyword reads a keyword as a Keyword instead of a sabre.Keyword so
+// that keywords can be invoked with the persistent maps.
+func readKeyword(rd *sabre.Reader, _ rune) (sabre.Value, error) {
+	var name []rune
+	for {
+		r, err := rd.NextRune()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		if rd.IsTerminal(r) {
+			rd.Unread(r)
+			break
+		}
+
+		name = append(name, r)
+	}
+
+	return Keyword(name), nil
+}
@@ -0,0 +1,600 @@
+package xlisp
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/spy16/sabre"
+)
+
+// LazySeq is a sequence whose values are computed only when they are first
+// accessed. The function producing the sequence is called at most once and
+// its result is cached.
+//
+// sabre.Seq methods cannot report errors, hence an error raised while
+// realizing the sequence makes it behave as an empty sequence. The error
+// is cached and returned by Seq, so the core sequence functions such as
+// first, next, count and doseq, as well as print, str, = and compare,
+// return it instead. See RealizeAll.
+type LazySeq struct {
+	mu  sync.Mutex
+	fn  func() (sabre.Value, error)
+	seq sabre.Seq
+	err error
+}
+
+// NewLazySeq returns a lazy sequence realized by calling fn. The function
+// must return a sequence or nil.
+func NewLazySeq(fn func() (sabre.Value, error)) *LazySeq {
+	return &LazySeq{fn: fn}
+}
+
+// Seq realizes the sequence and returns it, or nil if it is empty.
+func (ls *LazySeq) Seq() (sabre.Seq, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	if ls.fn != nil {
+		v, err := ls.fn()
+		ls.fn = nil
+
+		if err != nil {
+			ls.err = err
+		} else {
+			ls.seq, ls.err = toSeq(v)
+		}
+	}
+
+	return ls.seq, ls.err
+}
+
+// Eval returns the sequence itself without realizing it.
+func (ls *LazySeq) Eval(_ sabre.Scope) (sabre.Value, error) {
+	return ls, nil
+}
+
+// First realizes the sequence and returns its first value.
+func (ls *LazySeq) First() sabre.Value {
+	s, _ := ls.Seq()
+	if s == nil {
+		return nil
+	}
+
+	return s.First()
+}
+
+// Next realizes the sequence and returns the values after the first one.
+func (ls *LazySeq) Next() sabre.Seq {
+	s, _ := ls.Seq()
+	if s == nil {
+		return nil
+	}
+
+	next, _ := nextSeq(s)
+	return next
+}
+
+// Cons returns a new sequence with the value prepended without realizing
+// the sequence.
+func (ls *LazySeq) Cons(v sabre.Value) sabre.Seq {
+	return &Cons{first: v, rest: ls}
+}
+
+// Conj realizes the sequence and appends the values to it.
+func (ls *LazySeq) Conj(vals ...sabre.Value) sabre.Seq {
+	return Realize(ls).Conj(vals...)
+}
+
+// Size realizes the sequence and returns the number of values in it.
+func (ls *LazySeq) Size() int {
+	return seqSize(ls)
+}
+
+// Compare realizes the sequence and compares it with the other sequence.
+func (ls *LazySeq) Compare(v sabre.Value) bool {
+	return Realize(ls).Compare(v)
+}
+
+func (ls *LazySeq) String() string {
+	return Realize(ls).String()
+}
+
+// Cons is a sequence made of a value followed by another sequence. Consing
+// onto a lazy sequence returns a Cons so that the sequence is not realized.
+type Cons struct {
+	first sabre.Value
+	rest  sabre.Seq
+}
+
+// Eval returns the sequence itself.
+func (c *Cons) Eval(_ sabre.Scope) (sabre.Value, error) {
+	return c, nil
+}
+
+// First returns the first value of the sequence.
+func (c *Cons) First() sabre.Value {
+	return c.first
+}
+
+// Next returns the rest of the sequence or nil if it is empty.
+func (c *Cons) Next() sabre.Seq {
+	next, _ := toSeq(c.rest)
+	return next
+}
+
+// Cons returns a new sequence with the value prepended.
+func (c *Cons) Cons(v sabre.Value) sabre.Seq {
+	return &Cons{first: v, rest: c}
+}
+
+// Conj realizes the sequence and appends the values to it.
+func (c *Cons) Conj(vals ...sabre.Value) sabre.Seq {
+	return Realize(c).Conj(vals...)
+}
+
+// Size realizes the sequence and returns the number of values in it.
+func (c *Cons) Size() int {
+	return seqSize(c)
+}
+
+// Compare realizes the sequence and compares it with the other sequence.
+func (c *Cons) Compare(v sabre.Value) bool {
+	return Realize(c).Compare(v)
+}
+
+func (c *Cons) String() string {
+	return Realize(c).String()
+}
+
+// Range is a sequence of integers from Start (inclusive) to End (exclusive)
+// by Step. An infinite range has no end.
+type Range struct {
+	Start, End, Step int64
+	Infinite         bool
+}
+
+// Eval returns the range itself.
+func (r *Range) Eval(_ sabre.Scope) (sabre.Value, error) {
+	return r, nil
+}
+
+func (r *Range) empty() bool {
+	switch {
+	case r.Infinite:
+		return false
+	case r.Step > 0:
+		return r.Start >= r.End
+	case r.Step < 0:
+		return r.Start <= r.End
+	default:
+		return r.Start == r.End
+	}
+}
+
+// First returns the first integer of the range.
+func (r *Range) First() sabre.Value {
+	if r.empty() {
+		return nil
+	}
+
+	return sabre.Int64(r.Start)
+}
+
+// Next returns the range without its first integer.
+func (r *Range) Next() sabre.Seq {
+	next := *r
+	next.Start += r.Step
+	if next.empty() {
+		return nil
+	}
+
+	return &next
+}
+
+// Cons returns a new sequence with the value prepended.
+func (r *Range) Cons(v sabre.Value) sabre.Seq {
+	return &Cons{first: v, rest: r}
+}
+
+// Conj realizes the range and appends the values to it.
+func (r *Range) Conj(vals ...sabre.Value) sabre.Seq {
+	return Realize(r).Conj(vals...)
+}
+
+// Size returns the number of integers in the range or -1 if it is
+// infinite.
+func (r *Range) Size() int {
+	switch {
+	case r.Infinite || r.Step == 0 && !r.empty():
+		return -1
+	case r.empty():
+		return 0
+	}
+
+	n := (r.End - r.Start + r.Step - sign(r.Step)) / r.Step
+	return int(n)
+}
+
+// Compare compares the range with the other sequence.
+func (r *Range) Compare(v sabre.Value) bool {
+	if r.Size() < 0 {
+		return v == sabre.Value(r)
+	}
+
+	return Realize(r).Compare(v)
+}
+
+func (r *Range) String() string {
+	if r.Size() < 0 {
+		return fmt.Sprintf("(range %d)", r.Start)
+	}
+
+	return Realize(r).String()
+}
+
+func sign(n int64) int64 {
+	if n < 0 {
+		return -1
+	}
+	return 1
+}
+
+// toSeq converts the value to a non-empty sequence, realizing lazy
+// sequences. Returns nil if the value is nil or an empty sequence.
+func toSeq(v sabre.Value) (sabre.Seq, error) {
+	switch s := v.(type) {
+	case nil, sabre.Nil:
+		return nil, nil
+
+	case *LazySeq:
+		return s.Seq()
+
+	case sabre.Seq:
+		if s.First() == nil {
+			return nil, nil
+		}
+		return s, nil
+	}
+
+	return nil, fmt.Errorf("don't know how to create sequence from %s",
+		reflect.TypeOf(v))
+}
+
+// isLazy returns true if the value is a sequence whose values may raise
+// errors when they are realized.
+func isLazy(v sabre.Value) bool {
+	switch v.(type) {
+	case *LazySeq, *Cons:
+		return true
+	}
+
+	return false
+}
+
+// nextSeq returns the non-empty sequence of values after the first value
+// of 's' or nil.
+func nextSeq(s sabre.Seq) (sabre.Seq, error) {
+	switch seq := s.(type) {
+	case *LazySeq:
+		inner, err := seq.Seq()
+		if err != nil || inner == nil {
+			return nil, err
+		}
+		return nextSeq(inner)
+
+	case *Cons:
+		return toSeq(seq.rest)
+	}
+
+	return toSeq(s.Next())
+}
+
+// forEach calls fn with each value of the sequence in order until fn
+// returns an error.
+func forEach(v sabre.Value, fn func(v sabre.Value) error) error {
+	s, err := toSeq(v)
+	for err == nil && s != nil {
+		if err := fn(s.First()); err != nil {
+			return err
+		}
+
+		s, err = nextSeq(s)
+	}
+
+	return err
+}
+
+// RealizeAll realizes the lazy sequences in the value, including the ones
+// nested in collections, and returns the first error raised while
+// realizing them. Values are realized with it before they are printed
+// since their String methods cannot report errors. Infinite ranges are
+// left as they are.
+func RealizeAll(v sabre.Value) error {
+	switch coll := v.(type) {
+	case *LazySeq, *Cons, *vectorSeq:
+		return forEach(coll, RealizeAll)
+
+	case *sabre.List:
+		return realizeValues(coll.Values)
+
+	case *Vector:
+		return realizeValues(coll.Values())
+
+	case *Set:
+		return realizeValues(coll.Values())
+
+	case *HashMap:
+		var err error
+		coll.each(func(k, v sabre.Value) bool {
+			err = realizeValues([]sabre.Value{k, v})
+			return err == nil
+		})
+		return err
+	}
+
+	return nil
+}
+
+func realizeValues(vals []sabre.Value) error {
+	for _, v := range vals {
+		if err := RealizeAll(v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func seqSize(s sabre.Seq) int {
+	n := 0
+	_ = forEach(s, func(_ sabre.Value) error {
+		n++
+		return nil
+	})
+	return n
+}
+
+// captureScope returns a scope holding the local bindings visible from the
+// given scope. Since function scopes are chained to the scope of their
+// caller, evaluating a lazy sequence in the scope it was created in would
+// make the chain grow with every realized value.
+//
+// The dynamic bindings are captured as well, so lazy sequences keep the
+// bindings in effect where they are created. Clojure uses the bindings in
+// effect where a sequence is realized instead, but values are realized
+// here without access to the scope of the caller.
+func captureScope(scope sabre.Scope) *localScope {
+	snap := &localScope{
+		ns:       scopeNS(scope),
+		state:    stateOf(scope),
+		bindings: map[string]sabre.Value{},
+		dynamic:  map[*Var]sabre.Value{},
+	}
+
+	cur := scope
+	for ; cur != nil; cur = cur.Parent() {
+		s, ok := cur.(*localScope)
+		if !ok {
+			break
+		}
+
+		s.mu.RLock()
+		for sym, v := range s.bindings {
+			if _, found := snap.bindings[sym]; !found {
+				snap.bindings[sym] = v
+			}
+		}
+		s.mu.RUnlock()
+
+		for vr, v := range s.dynamic {
+			if _, found := snap.dynamic[vr]; !found {
+				snap.dynamic[vr] = v
+			}
+		}
+	}
+
+	snap.parent = cur
+	return snap
+}
+
+// lazySeq implements (lazy-seq body*). The body is evaluated when the
+// sequence is first accessed and must return a sequence or nil.
+func lazySeq(scope sabre.Scope, body []sabre.Value) (sabre.Value, error) {
+	captured := captureScope(scope)
+
+	return NewLazySeq(func() (sabre.Value, error) {
+		return sabre.Module(body).Eval(captured)
+	}), nil
+}
+
+// slangRange implements (range), (range end), (range start end) and
+// (range start end step).
+func slangRange(args ...int64) (*Range, error) {
+	switch len(args) {
+	case 0:
+		return &Range{Step: 1, Infinite: true}, nil
+	case 1:
+		return &Range{End: args[0], Step: 1}, nil
+	case 2:
+		return &Range{Start: args[0], End: args[1], Step: 1}, nil
+	case 3:
+		return &Range{Start: args[0], End: args[1], Step: args[2]}, nil
+	}
+
+	return nil, fmt.Errorf("wrong number of args (%d) to 'range'", len(args))
+}
+
+// iterate returns the infinite sequence x, (f x), (f (f x)) and so on.
+func iterate(scope sabre.Scope, f sabre.Invokable, x sabre.Value) sabre.Seq {
+	return &Cons{
+		first: x,
+		rest: NewLazySeq(func() (sabre.Value, error) {
+			v, err := invoke(scope, f, x)
+			if err != nil {
+				return nil, err
+			}
+
+			return iterate(scope, f, v), nil
+		}),
+	}
+}
+
+// repeat implements (repeat x) and (repeat n x).
+func repeat(args ...sabre.Value) (sabre.Seq, error) {
+	switch len(args) {
+	case 1:
+		return repeatN(-1, args[0]), nil
+
+	case 2:
+		n, ok := args[0].(sabre.Int64)
+		if !ok {
+			return nil, fmt.Errorf("repeat count must be an integer, not %s",
+				reflect.TypeOf(args[0]))
+		}
+		return repeatN(int64(n), args[1]), nil
+	}
+
+	return nil, fmt.Errorf("wrong number of args (%d) to 'repeat'", len(args))
+}
+
+func repeatN(n int64, x sabre.Value) sabre.Seq {
+	return NewLazySeq(func() (sabre.Value, error) {
+		if n == 0 {
+			return sabre.Nil{}, nil
+		}
+
+		return &Cons{first: x, rest: repeatN(n-1, x)}, nil
+	})
+}
+
+// cycle returns an infinite sequence repeating the values of the
+// collection.
+func cycle(coll sabre.Value) sabre.Seq {
+	return cycleFrom(coll, coll)
+}
+
+func cycleFrom(cur, coll sabre.Value) sabre.Seq {
+	return NewLazySeq(func() (sabre.Value, error) {
+		s, err := toSeq(cur)
+		if err != nil {
+			return nil, err
+		}
+
+		if s == nil {
+			if s, err = toSeq(coll); err != nil || s == nil {
+				return nil, err
+			}
+		}
+
+		next, err := nextSeq(s)
+		if err != nil {
+			return nil, err
+		}
+
+		var rest sabre.Value = sabre.Nil{}
+		if next != nil {
+			rest = next
+		}
+
+		return &Cons{first: s.First(), rest: cycleFrom(rest, coll)}, nil
+	})
+}
+
+// invoke calls the function with already evaluated arguments.
+func invoke(scope sabre.Scope, f sabre.Invokable, args ...sabre.Value) (sabre.Value, error) {
+	quoted := make([]sabre.Value, len(args))
+	for i, arg := range args {
+		quoted[i] = &sabre.List{
+			Values: []sabre.Value{sabre.Symbol{Value: "core/quote"}, arg},
+		}
+	}
+
+	return f.Invoke(scope, quoted...)
+}
+
+// seqOf returns the value as a sequence or nil if it is empty.
+func seqOf(v sabre.Value) (sabre.Value, error) {
+	s, err := toSeq(v)
+	if err != nil || s == nil {
+		return sabre.Nil{}, err
+	}
+
+	return s, nil
+}
+
+// seqFirst returns the first value of the collection or nil.
+func seqFirst(v sabre.Value) (sabre.Value, error) {
+	s, err := toSeq(v)
+	if err != nil || s == nil {
+		return sabre.Nil{}, err
+	}
+
+	return s.First(), nil
+}
+
+// seqNext returns the values after the first value of the collection or
+// nil if there are none.
+func seqNext(v sabre.Value) (sabre.Value, error) {
+	s, err := toSeq(v)
+	if err != nil || s == nil {
+		return sabre.Nil{}, err
+	}
+
+	n, err := nextSeq(s)
+	if err != nil || n == nil {
+		return sabre.Nil{}, err
+	}
+
+	return n, nil
+}
+
+// seqRest is like seqNext but returns an empty list instead of nil. The
+// rest of a lazy sequence is returned without realizing it.
+func seqRest(v sabre.Value) (sabre.Value, error) {
+	s, err := toSeq(v)
+	if err != nil {
+		return nil, err
+	}
+
+	if c, isCons := s.(*Cons); isCons && c.rest != nil {
+		return c.rest, nil
+	}
+
+	if s != nil {
+		if next := s.Next(); next != nil {
+			return next, nil
+		}
+	}
+
+	return &sabre.List{}, nil
+}
+
+// seqCount returns the number of values in the collection, realizing lazy
+// sequences.
+func seqCount(v sabre.Value) (int, error) {
+	switch coll := v.(type) {
+	case sabre.Nil:
+		return 0, nil
+
+	case *Range:
+		if n := coll.Size(); n >= 0 {
+			return n, nil
+		}
+		return 0, fmt.Errorf("cannot count infinite range")
+
+	case *LazySeq, *Cons:
+		n := 0
+		err := forEach(coll, func(_ sabre.Value) error {
+			n++
+			return nil
+		})
+		return n, err
+
+	case interface{ Size() int }:
+		return coll.Size(), nil
+	}
+
+	return 0, fmt.Errorf("argument must be a collection, not %s",
+		reflect.TypeOf(v))
+}
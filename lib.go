@@ -0,0 +1,13 @@
+package xlisp
+
+import (
+	// embeds the core library.
+	_ "embed"
+)
+
+// coreLibrary is the source of the core library which defines the
+// functions and macros implemented in xlisp itself such as defn, map and
+// filter. It is loaded by New unless disabled with WithoutCoreLibrary.
+//
+//go:embed lib/core.xlisp
+var coreLibrary string
@@ -1,146 +1,719 @@
 package xlisp
 
 import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+
 	"github.com/spy16/sabre"
 )
 
-type Any interface{}
+var (
+	errDivideByZero    = errors.New("divide by zero")
+	errIntegerOverflow = errors.New("integer overflow")
+)
+
+type arithOp int
 
-// Add adds given floating point numbers and returns the sum.
-func Add(args ...Any) Any {
-	switch args[0].(type) {
-	case sabre.Int64:
-		var sum sabre.Int64
-		for _, a := range args {
-			sum += a.(sabre.Int64)
+const (
+	opAdd arithOp = iota
+	opSub
+	opMul
+	opDiv
+)
+
+// Add returns the sum of the numbers. Overflowing Int64 addition is an
+// error, use AddPromote to get a BigInt instead.
+func Add(args ...sabre.Value) (sabre.Value, error) {
+	return fold(opAdd, false, sabre.Int64(0), args)
+}
+
+// AddPromote is like Add but promotes the sum to BigInt on overflow.
+func AddPromote(args ...sabre.Value) (sabre.Value, error) {
+	return fold(opAdd, true, sabre.Int64(0), args)
+}
+
+// Sub subtracts args from 'x' and returns the final result. Returns the
+// negation of 'x' if there are no args.
+func Sub(x sabre.Value, args ...sabre.Value) (sabre.Value, error) {
+	if len(args) == 0 {
+		return arith(opSub, false, sabre.Int64(0), x)
+	}
+	return fold(opSub, false, x, args)
+}
+
+// SubPromote is like Sub but promotes the result to BigInt on overflow.
+func SubPromote(x sabre.Value, args ...sabre.Value) (sabre.Value, error) {
+	if len(args) == 0 {
+		return arith(opSub, true, sabre.Int64(0), x)
+	}
+	return fold(opSub, true, x, args)
+}
+
+// Multiply returns the product of the numbers. Overflowing Int64
+// multiplication is an error, use MultiplyPromote to get a BigInt instead.
+func Multiply(args ...sabre.Value) (sabre.Value, error) {
+	return fold(opMul, false, sabre.Int64(1), args)
+}
+
+// MultiplyPromote is like Multiply but promotes the product to BigInt on
+// overflow.
+func MultiplyPromote(args ...sabre.Value) (sabre.Value, error) {
+	return fold(opMul, true, sabre.Int64(1), args)
+}
+
+// Divide divides 'x' by args and returns the final result. Returns the
+// reciprocal of 'x' if there are no args. Dividing integers returns an
+// integer if the division is exact and a Ratio otherwise.
+func Divide(x sabre.Value, args ...sabre.Value) (sabre.Value, error) {
+	if len(args) == 0 {
+		return arith(opDiv, false, sabre.Int64(1), x)
+	}
+	return fold(opDiv, false, x, args)
+}
+
+func fold(op arithOp, promote bool, acc sabre.Value, args []sabre.Value) (sabre.Value, error) {
+	if _, err := kindOf(acc); err != nil {
+		return nil, err
+	}
+
+	for _, arg := range args {
+		var err error
+		if acc, err = arith(op, promote, acc, arg); err != nil {
+			return nil, err
 		}
-		return sum
-	case sabre.Float64:
-		var sum sabre.Float64
-		for _, a := range args {
-			sum += a.(sabre.Float64)
+	}
+
+	return acc, nil
+}
+
+// arith applies the operation to the numbers after converting both to the
+// larger kind of the two.
+func arith(op arithOp, promote bool, a, b sabre.Value) (sabre.Value, error) {
+	ka, err := kindOf(a)
+	if err != nil {
+		return nil, err
+	}
+
+	kb, err := kindOf(b)
+	if err != nil {
+		return nil, err
+	}
+
+	kind := ka
+	if kb > kind {
+		kind = kb
+	}
+
+	switch kind {
+	case kindInt:
+		x, y := int64(a.(sabre.Int64)), int64(b.(sabre.Int64))
+		if res, ok := intArith(op, x, y); ok {
+			return sabre.Int64(res), nil
+		}
+
+		if op == opDiv {
+			if y == 0 {
+				return nil, errDivideByZero
+			}
+			return normalizeRat(big.NewRat(x, y), true), nil
 		}
-		return sum
+
+		if !promote {
+			return nil, errIntegerOverflow
+		}
+		return bigArith(op, toBig(a), toBig(b))
+
+	case kindBigInt:
+		return bigArith(op, toBig(a), toBig(b))
+
+	case kindRatio:
+		return ratArith(op, toRat(a), toRat(b))
+
+	case kindDecimal:
+		return decimalArith(op, a, b)
+	}
+
+	return floatArith(op, toFloat64(a), toFloat64(b)), nil
+}
+
+// intArith returns the result of the operation and false if it overflows
+// or, for division, is not an exact integer.
+func intArith(op arithOp, x, y int64) (int64, bool) {
+	switch op {
+	case opAdd:
+		res := x + y
+		return res, (x^res)&(y^res) >= 0
+
+	case opSub:
+		res := x - y
+		return res, (x^y)&(x^res) >= 0
+
+	case opMul:
+		if x == 0 || y == 0 {
+			return 0, true
+		}
+
+		res := x * y
+		if (x == -1 && y == math.MinInt64) || (y == -1 && x == math.MinInt64) {
+			return 0, false
+		}
+		return res, res/y == x
+	}
+
+	if y == 0 || x%y != 0 || (x == math.MinInt64 && y == -1) {
+		return 0, false
+	}
+	return x / y, true
+}
+
+func bigArith(op arithOp, x, y *big.Int) (sabre.Value, error) {
+	res := new(big.Int)
+	switch op {
+	case opAdd:
+		res.Add(x, y)
+	case opSub:
+		res.Sub(x, y)
+	case opMul:
+		res.Mul(x, y)
 	default:
-		return nil
+		if y.Sign() == 0 {
+			return nil, errDivideByZero
+		}
+		return normalizeRat(new(big.Rat).SetFrac(x, y), false), nil
 	}
+
+	return BigInt{i: res}, nil
 }
 
-// Sub subtracts args from 'x' and returns the final result.
-func Sub(x Any, args ...Any) Any {
-	switch x.(type) {
-	case sabre.Float64:
-		var result sabre.Float64 = x.(sabre.Float64)
-		if len(args) == 0 {
-			return -1 * x.(sabre.Float64)
+func ratArith(op arithOp, x, y *big.Rat) (sabre.Value, error) {
+	res := new(big.Rat)
+	switch op {
+	case opAdd:
+		res.Add(x, y)
+	case opSub:
+		res.Sub(x, y)
+	case opMul:
+		res.Mul(x, y)
+	default:
+		if y.Sign() == 0 {
+			return nil, errDivideByZero
 		}
+		res.Quo(x, y)
+	}
+
+	return normalizeRat(res, false), nil
+}
+
+func decimalArith(op arithOp, a, b sabre.Value) (sabre.Value, error) {
+	x, err := toDecimal(a)
+	if err != nil {
+		return nil, err
+	}
+
+	y, err := toDecimal(b)
+	if err != nil {
+		return nil, err
+	}
 
-		for _, a := range args {
-			result -= a.(sabre.Float64)
+	switch op {
+	case opMul:
+		return BigDecimal{
+			unscaled: new(big.Int).Mul(x.unscaled, y.unscaled),
+			scale:    x.scale + y.scale,
+		}, nil
+
+	case opDiv:
+		if y.unscaled.Sign() == 0 {
+			return nil, errDivideByZero
 		}
-		return result
-	case sabre.Int64:
-		var result sabre.Int64 = x.(sabre.Int64)
-		if len(args) == 0 {
-			return -1 * x.(sabre.Int64)
+		return ratToDecimal(new(big.Rat).Quo(x.Rat(), y.Rat()))
+	}
+
+	// align the scales before adding or subtracting.
+	scale := x.scale
+	if y.scale > scale {
+		scale = y.scale
+	}
+
+	xu := new(big.Int).Mul(x.unscaled, pow10(scale-x.scale))
+	yu := new(big.Int).Mul(y.unscaled, pow10(scale-y.scale))
+	if op == opAdd {
+		return BigDecimal{unscaled: xu.Add(xu, yu), scale: scale}, nil
+	}
+	return BigDecimal{unscaled: xu.Sub(xu, yu), scale: scale}, nil
+}
+
+func floatArith(op arithOp, x, y float64) sabre.Value {
+	switch op {
+	case opAdd:
+		return sabre.Float64(x + y)
+	case opSub:
+		return sabre.Float64(x - y)
+	case opMul:
+		return sabre.Float64(x * y)
+	}
+	return sabre.Float64(x / y)
+}
+
+// numEquiv implements (== x & more) which returns true if all the numbers
+// have the same value regardless of their types.
+func numEquiv(x sabre.Value, args ...sabre.Value) (bool, error) {
+	if _, err := kindOf(x); err != nil {
+		return false, err
+	}
+
+	for _, arg := range args {
+		c, err := numCompare(x, arg)
+		if err != nil {
+			return false, err
 		}
 
-		for _, a := range args {
-			result -= a.(sabre.Int64)
+		if c != 0 || isNaN(x) || isNaN(arg) {
+			return false, nil
 		}
-		return result
-	default:
-		return nil
 	}
+
+	return true, nil
 }
 
-// Multiply multiplies the given args to 1 and returns the result.
-func Multiply(first Any, args ...Any) Any {
-	switch args[0].(type) {
+// numAbs implements (math/abs x).
+func numAbs(x sabre.Value) (sabre.Value, error) {
+	switch n := x.(type) {
 	case sabre.Int64:
-		result := first.(sabre.Int64)
-		for _, a := range args {
-			result *= a.(sabre.Int64)
+		if n == math.MinInt64 {
+			return nil, errIntegerOverflow
 		}
-		return result
+		if n < 0 {
+			return -n, nil
+		}
+		return n, nil
+
 	case sabre.Float64:
-		result := first.(sabre.Float64)
-		for _, a := range args {
-			result *= a.(sabre.Float64)
+		return sabre.Float64(math.Abs(float64(n))), nil
+
+	case BigInt:
+		return BigInt{i: new(big.Int).Abs(n.i)}, nil
+
+	case Ratio:
+		return Ratio{r: new(big.Rat).Abs(n.r)}, nil
+
+	case BigDecimal:
+		return BigDecimal{unscaled: new(big.Int).Abs(n.unscaled), scale: n.scale}, nil
+	}
+
+	return nil, notNumber(x)
+}
+
+// numMin implements (math/min x & more).
+func numMin(x sabre.Value, args ...sabre.Value) (sabre.Value, error) {
+	return extremum(-1, x, args)
+}
+
+// numMax implements (math/max x & more).
+func numMax(x sabre.Value, args ...sabre.Value) (sabre.Value, error) {
+	return extremum(1, x, args)
+}
+
+// extremum returns the smallest (want = -1) or the largest (want = 1) of
+// the numbers. The result is NaN if any of the numbers is NaN.
+func extremum(want int, x sabre.Value, args []sabre.Value) (sabre.Value, error) {
+	if _, err := kindOf(x); err != nil {
+		return nil, err
+	}
+
+	res := x
+	for _, arg := range args {
+		c, err := numCompare(arg, res)
+		if err != nil {
+			return nil, err
+		}
+
+		if isNaN(arg) || (c == want && !isNaN(res)) {
+			res = arg
 		}
-		return result
-	default:
-		return nil
 	}
+
+	return res, nil
 }
 
-// Divide returns the product of given numbers.
-func Divide(first Any, args ...Any) Any {
+// numPow implements (math/pow x y). The result is exact if x is an exact
+// number and y an Int64, a Float64 otherwise.
+func numPow(x, y sabre.Value) (sabre.Value, error) {
+	kx, err := kindOf(x)
+	if err != nil {
+		return nil, err
+	}
 
-	switch first.(type) {
-	case sabre.Float64:
-		var result sabre.Float64 = first.(sabre.Float64)
+	if _, err := kindOf(y); err != nil {
+		return nil, err
+	}
 
-		if len(args) == 0 {
-			return 1 / first.(sabre.Float64)
+	exp, isInt := y.(sabre.Int64)
+	if !isInt || kx == kindFloat {
+		return sabre.Float64(math.Pow(toFloat64(x), toFloat64(y))), nil
+	}
+
+	e := new(big.Int).Abs(big.NewInt(int64(exp)))
+	var res sabre.Value
+	switch kx {
+	case kindInt, kindBigInt:
+		res = normalizeInt(new(big.Int).Exp(toBig(x), e, nil), kx == kindInt)
+
+	case kindRatio:
+		r := toRat(x)
+		res = normalizeRat(new(big.Rat).SetFrac(
+			new(big.Int).Exp(r.Num(), e, nil),
+			new(big.Int).Exp(r.Denom(), e, nil),
+		), false)
+
+	case kindDecimal:
+		d := x.(BigDecimal)
+		res = BigDecimal{
+			unscaled: new(big.Int).Exp(d.unscaled, e, nil),
+			scale:    d.scale * int(e.Int64()),
 		}
+	}
+
+	if exp >= 0 {
+		return res, nil
+	}
+	return arith(opDiv, false, sabre.Int64(1), res)
+}
 
-		for _, a := range args {
-			result /= a.(sabre.Float64)
+// floatFn returns a function applying f to a number converted to Float64.
+func floatFn(f func(float64) float64) func(sabre.Value) (sabre.Value, error) {
+	return func(x sabre.Value) (sabre.Value, error) {
+		if _, err := kindOf(x); err != nil {
+			return nil, err
 		}
-		return result
+		return sabre.Float64(f(toFloat64(x))), nil
+	}
+}
 
-	case sabre.Int64:
-		var result sabre.Int64 = first.(sabre.Int64)
+// numAtan2 implements (math/atan2 y x).
+func numAtan2(y, x sabre.Value) (sabre.Value, error) {
+	if _, err := kindOf(y); err != nil {
+		return nil, err
+	}
+
+	if _, err := kindOf(x); err != nil {
+		return nil, err
+	}
 
-		if len(args) == 0 {
-			return 1 / sabre.Float64(first.(sabre.Int64))
+	return sabre.Float64(math.Atan2(toFloat64(y), toFloat64(x))), nil
+}
+
+type roundMode int
+
+const (
+	roundFloor roundMode = iota
+	roundCeil
+	roundHalfUp
+)
+
+// roundFn returns a function rounding a number to an integral value.
+// Integers are returned as is, floats are floored and ceiled to a Float64
+// but rounded to an integer, decimals are rounded to a BigDecimal with no
+// fraction digits and ratios to an integer. Halves are rounded up, towards
+// positive infinity.
+func roundFn(mode roundMode) func(sabre.Value) (sabre.Value, error) {
+	return func(x sabre.Value) (sabre.Value, error) {
+		switch n := x.(type) {
+		case sabre.Int64, BigInt:
+			return n, nil
+
+		case sabre.Float64:
+			switch mode {
+			case roundFloor:
+				return sabre.Float64(math.Floor(float64(n))), nil
+			case roundCeil:
+				return sabre.Float64(math.Ceil(float64(n))), nil
+			}
+			return roundFloat(float64(n))
+
+		case Ratio:
+			return normalizeInt(roundRat(mode, n.r), true), nil
+
+		case BigDecimal:
+			return BigDecimal{unscaled: roundRat(mode, n.Rat())}, nil
 		}
 
-		for _, a := range args {
-			result /= a.(sabre.Int64)
+		return nil, notNumber(x)
+	}
+}
+
+// roundFloat rounds the float to the nearest integer, halves up, as an
+// Int64 or as a BigInt if it is out of range. floor(f + 0.5) is avoided
+// since the addition rounds 0.49999999999999994 to 1.
+func roundFloat(f float64) (sabre.Value, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, fmt.Errorf("cannot round %v to an integer", f)
+	}
+
+	res := math.Floor(f)
+	if f-res >= 0.5 {
+		res++
+	}
+
+	if res >= math.MinInt64 && res < math.MaxInt64 {
+		return sabre.Int64(res), nil
+	}
+
+	i, _ := big.NewFloat(res).Int(nil)
+	return BigInt{i: i}, nil
+}
+
+func roundRat(mode roundMode, r *big.Rat) *big.Int {
+	switch mode {
+	case roundCeil:
+		neg := new(big.Rat).Neg(r)
+		return new(big.Int).Neg(roundRat(roundFloor, neg))
+
+	case roundHalfUp:
+		return roundRat(roundFloor, new(big.Rat).Add(r, big.NewRat(1, 2)))
+	}
+
+	// euclidean division by the positive denominator rounds down.
+	return new(big.Int).Div(r.Num(), r.Denom())
+}
+
+// quot implements (quot x y), the quotient of dividing x by y rounded
+// towards zero.
+func quot(x, y sabre.Value) (sabre.Value, error) {
+	q, _, err := quotRem(x, y)
+	return q, err
+}
+
+// rem implements (rem x y), the remainder of dividing x by y. The result
+// has the sign of x.
+func rem(x, y sabre.Value) (sabre.Value, error) {
+	_, r, err := quotRem(x, y)
+	return r, err
+}
+
+// mod implements (mod x y), the modulus of x and y. Unlike rem, the result
+// has the sign of y.
+func mod(x, y sabre.Value) (sabre.Value, error) {
+	_, r, err := quotRem(x, y)
+	if err != nil {
+		return nil, err
+	}
+
+	rs, err := numCompare(r, sabre.Int64(0))
+	if err != nil {
+		return nil, err
+	}
+
+	ys, err := numCompare(y, sabre.Int64(0))
+	if err != nil {
+		return nil, err
+	}
+
+	if rs != 0 && rs != ys {
+		return arith(opAdd, false, r, y)
+	}
+	return r, nil
+}
+
+func quotRem(x, y sabre.Value) (sabre.Value, sabre.Value, error) {
+	kx, err := kindOf(x)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ky, err := kindOf(y)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	kind := kx
+	if ky > kind {
+		kind = ky
+	}
+
+	switch kind {
+	case kindInt:
+		a, b := x.(sabre.Int64), y.(sabre.Int64)
+		switch {
+		case b == 0:
+			return nil, nil, errDivideByZero
+		case a == math.MinInt64 && b == -1:
+			return nil, nil, errIntegerOverflow
+		}
+		return a / b, a % b, nil
+
+	case kindFloat:
+		a, b := toFloat64(x), toFloat64(y)
+		return sabre.Float64(math.Trunc(a / b)), sabre.Float64(math.Mod(a, b)), nil
+
+	case kindBigInt:
+		if toBig(y).Sign() == 0 {
+			return nil, nil, errDivideByZero
+		}
+
+		q, r := new(big.Int).QuoRem(toBig(x), toBig(y), new(big.Int))
+		return BigInt{i: q}, BigInt{i: r}, nil
+	}
+
+	b := toRat(y)
+	if b.Sign() == 0 {
+		return nil, nil, errDivideByZero
+	}
+
+	div := new(big.Rat).Quo(toRat(x), b)
+	qi := new(big.Int).Quo(div.Num(), div.Denom())
+
+	var q sabre.Value = BigInt{i: qi}
+	if kind == kindDecimal {
+		q = BigDecimal{unscaled: qi}
+	}
+
+	prod, err := arith(opMul, false, q, y)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r, err := arith(opSub, false, x, prod)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return q, r, nil
+}
+
+// isNaNValue implements (math/NaN? x).
+func isNaNValue(x sabre.Value) (bool, error) {
+	if _, err := kindOf(x); err != nil {
+		return false, err
+	}
+	return isNaN(x), nil
+}
+
+// isInfinite implements (math/infinite? x).
+func isInfinite(x sabre.Value) (bool, error) {
+	if _, err := kindOf(x); err != nil {
+		return false, err
+	}
+
+	f, ok := x.(sabre.Float64)
+	return ok && math.IsInf(float64(f), 0), nil
+}
+
+type bitOp int
+
+const (
+	bitAnd bitOp = iota
+	bitOr
+	bitXor
+)
+
+// bitFn returns a function applying the bitwise operation to integers.
+func bitFn(op bitOp) func(sabre.Value, sabre.Value, ...sabre.Value) (sabre.Value, error) {
+	return func(x, y sabre.Value, more ...sabre.Value) (sabre.Value, error) {
+		acc := x
+		for _, arg := range append([]sabre.Value{y}, more...) {
+			var err error
+			if acc, err = bitwise(op, acc, arg); err != nil {
+				return nil, err
+			}
+		}
+		return acc, nil
+	}
+}
+
+func bitwise(op bitOp, x, y sabre.Value) (sabre.Value, error) {
+	if err := checkInteger(x, y); err != nil {
+		return nil, err
+	}
+
+	a, aSmall := x.(sabre.Int64)
+	b, bSmall := y.(sabre.Int64)
+	if aSmall && bSmall {
+		switch op {
+		case bitAnd:
+			return a & b, nil
+		case bitOr:
+			return a | b, nil
 		}
-		return result
+		return a ^ b, nil
+	}
+
+	res := new(big.Int)
+	switch op {
+	case bitAnd:
+		res.And(toBig(x), toBig(y))
+	case bitOr:
+		res.Or(toBig(x), toBig(y))
 	default:
-		return nil
+		res.Xor(toBig(x), toBig(y))
+	}
+	return BigInt{i: res}, nil
+}
+
+// bitNot implements (math/bit-not x).
+func bitNot(x sabre.Value) (sabre.Value, error) {
+	if err := checkInteger(x); err != nil {
+		return nil, err
 	}
 
+	if n, ok := x.(sabre.Int64); ok {
+		return ^n, nil
+	}
+	return BigInt{i: new(big.Int).Not(toBig(x))}, nil
 }
 
-// Lt returns true if the given args are monotonically increasing.
-func Lt(base float64, args ...float64) bool {
-	inc := true
-	for _, arg := range args {
-		inc = inc && (arg > base)
+// bitShiftLeft implements (math/bit-shift-left x n). Int64 values wrap
+// around like the << operator of Go.
+func bitShiftLeft(x sabre.Value, n sabre.Int64) (sabre.Value, error) {
+	if err := checkShift(x, n); err != nil {
+		return nil, err
+	}
+
+	if i, ok := x.(sabre.Int64); ok {
+		return i << uint(n&63), nil
 	}
-	return inc
+	return BigInt{i: new(big.Int).Lsh(toBig(x), uint(n))}, nil
 }
 
-// LtE returns true if the given args are monotonically increasing or
-// are all equal.
-func LtE(base float64, args ...float64) bool {
-	inc := true
-	for _, arg := range args {
-		inc = inc && (arg >= base)
+// bitShiftRight implements (math/bit-shift-right x n), an arithmetic
+// shift preserving the sign.
+func bitShiftRight(x sabre.Value, n sabre.Int64) (sabre.Value, error) {
+	if err := checkShift(x, n); err != nil {
+		return nil, err
+	}
+
+	if i, ok := x.(sabre.Int64); ok {
+		return i >> uint(n&63), nil
 	}
-	return inc
+	return BigInt{i: new(big.Int).Rsh(toBig(x), uint(n))}, nil
 }
 
-// Gt returns true if the given args are monotonically decreasing.
-func Gt(base float64, args ...float64) bool {
-	inc := true
-	for _, arg := range args {
-		inc = inc && (arg < base)
+// unsignedBitShiftRight implements (math/unsigned-bit-shift-right x n)
+// which shifts zeros into the sign bit of an Int64.
+func unsignedBitShiftRight(x sabre.Int64, n sabre.Int64) (sabre.Value, error) {
+	if n < 0 {
+		return nil, fmt.Errorf("shift amount must not be negative, got %d", n)
 	}
-	return inc
+	return sabre.Int64(uint64(x) >> uint(n&63)), nil
 }
 
-// GtE returns true if the given args are monotonically decreasing or
-// all equal.
-func GtE(base float64, args ...float64) bool {
-	inc := true
-	for _, arg := range args {
-		inc = inc && (arg <= base)
+func checkShift(x sabre.Value, n sabre.Int64) error {
+	if n < 0 {
+		return fmt.Errorf("shift amount must not be negative, got %d", n)
+	}
+	return checkInteger(x)
+}
+
+func checkInteger(vals ...sabre.Value) error {
+	for _, v := range vals {
+		switch v.(type) {
+		case sabre.Int64, BigInt:
+		default:
+			return fmt.Errorf("bit operation not supported on %s", reflect.TypeOf(v))
+		}
 	}
-	return inc
+	return nil
 }
@@ -0,0 +1,334 @@
+package xlisp
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+
+	"github.com/spy16/sabre"
+)
+
+// Def binds the value of an expression to a symbol in the current
+// namespace. The symbol may carry metadata attached using the ^ reader
+// macro, e.g. (def ^:private x 10) defines a var which can only be resolved
+// from within its own namespace and (def ^:dynamic *x* 10) defines a var
+// which can be rebound using the binding form. A docstring given before the
+// value, e.g. (def x "The answer." 42), is attached to the var as :doc.
+var Def = sabre.SpecialForm{
+	Name:  "def",
+	Parse: parseDef,
+}
+
+// WithMeta returns a copy of the value with the given metadata attached,
+// nil removes the metadata. Only functions, vectors, hash-maps and sets
+// support metadata. Lists are not supported since sabre evaluates code as
+// *sabre.List values, which have no room for it.
+func WithMeta(v sabre.Value, meta sabre.Value) (sabre.Value, error) {
+	var m *HashMap
+	switch mv := meta.(type) {
+	case nil, sabre.Nil:
+
+	case *HashMap:
+		m = mv
+
+	default:
+		return nil, fmt.Errorf("metadata must be a hash-map, not %s",
+			reflect.TypeOf(meta))
+	}
+
+	switch val := v.(type) {
+	case *Fn:
+		fn := *val
+		fn.meta = m
+		return &fn, nil
+
+	case *Vector:
+		vec := *val
+		vec.meta = m
+		return &vec, nil
+
+	case *HashMap:
+		hm := *val
+		hm.meta = m
+		return &hm, nil
+
+	case *Set:
+		set := *val
+		set.meta = m
+		return &set, nil
+
+	default:
+		return nil, fmt.Errorf("cannot attach metadata to value of type %s: "+
+			"only functions, vectors, maps and sets support metadata",
+			reflect.TypeOf(v))
+	}
+}
+
+// Meta returns the metadata attached to the value or nil if there is none.
+// The metadata of a var, e.g. (meta #'inc), holds the attributes given to
+// def along with :ns, :file and :line, plus :doc and :arglists for the
+// functions and macros defined using defn and defmacro.
+func Meta(v sabre.Value) sabre.Value {
+	var meta *HashMap
+	switch val := v.(type) {
+	case *Fn:
+		meta = val.meta
+	case *Vector:
+		meta = val.meta
+	case *HashMap:
+		meta = val.meta
+	case *Set:
+		meta = val.meta
+	case *Var:
+		meta = val.Meta
+	}
+
+	if meta == nil {
+		return sabre.Nil{}
+	}
+	return meta
+}
+
+// metaFlag returns true if the key is set to a truthy value in the
+// metadata.
+func metaFlag(meta *HashMap, key string) bool {
+	if meta == nil {
+		return false
+	}
+
+	return isTruthy(meta.Get(Keyword(key), sabre.Nil{}))
+}
+
+func parseDef(scope sabre.Scope, forms []sabre.Value) (*sabre.Fn, error) {
+	if len(forms) != 2 && len(forms) != 3 {
+		return nil, fmt.Errorf("call requires 2 or 3 argument(s), got %d",
+			len(forms))
+	}
+
+	var doc sabre.Value
+	if len(forms) == 3 {
+		s, isString := forms[1].(sabre.String)
+		if !isString {
+			return nil, fmt.Errorf("docstring must be a string, not '%v'",
+				reflect.TypeOf(forms[1]))
+		}
+		doc = s
+	}
+
+	return &sabre.Fn{
+		Func: func(scope sabre.Scope, args []sabre.Value) (sabre.Value, error) {
+			sym, metaForms, err := defName(args[0])
+			if err != nil {
+				return nil, err
+			}
+
+			meta, err := evalMeta(scope, metaForms)
+			if err != nil {
+				return nil, err
+			}
+			meta = sourceMeta(sym).Conj(meta).(*HashMap)
+			if doc != nil {
+				meta = meta.Assoc(Keyword("doc"), doc)
+			}
+
+			v, err := args[len(args)-1].Eval(scope)
+			if err != nil {
+				return nil, err
+			}
+
+			root := rootScope(scope)
+			if xl, ok := root.(*Xlisp); ok {
+				err = xl.Define(sym.String(), v, meta)
+			} else {
+				err = root.Bind(sym.String(), v)
+			}
+
+			if err != nil {
+				return nil, err
+			}
+
+			return sym, nil
+		},
+	}, nil
+}
+
+// defName returns the symbol being defined along with the metadata forms
+// attached to it, outermost first.
+func defName(form sabre.Value) (sabre.Symbol, []sabre.Value, error) {
+	switch f := form.(type) {
+	case sabre.Symbol:
+		return f, nil, nil
+
+	case *sabre.List:
+		if isWithMeta(f) {
+			sym, meta, err := defName(f.Values[1])
+			if err != nil {
+				return sabre.Symbol{}, nil, err
+			}
+
+			return sym, append([]sabre.Value{f.Values[2]}, meta...), nil
+		}
+	}
+
+	return sabre.Symbol{}, nil, fmt.Errorf(
+		"first argument must be symbol, not '%v'", reflect.TypeOf(form))
+}
+
+// sourceMeta returns the :file and :line metadata of a var defined with the
+// symbol, if the symbol was read from source.
+func sourceMeta(sym sabre.Symbol) *HashMap {
+	t := NewHashMap().Transient()
+	if sym.File != "" {
+		t.assoc(Keyword("file"), sabre.String(sym.File))
+	}
+
+	if sym.Line > 0 {
+		t.assoc(Keyword("line"), sabre.Int64(sym.Line))
+	}
+
+	return t.persistent()
+}
+
+// defForm implements the expansion of defn and defmacro, see core.xlisp.
+// It returns (def name (kind name & fdecl)) where kind is fn* or macro*,
+// after moving the optional docstring and attribute map found before the
+// parameters of the function to the metadata of the var along with the
+// :arglists of the function. Attributes given as attrs take precedence.
+func defForm(kind sabre.Symbol, name, fdecl sabre.Value, attrs ...*HashMap) (sabre.Value, error) {
+	sym, _, err := defName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	decl, err := seqValues(fdecl)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := NewHashMap()
+	if len(decl) > 1 {
+		if doc, isString := decl[0].(sabre.String); isString {
+			meta = meta.Assoc(Keyword("doc"), doc)
+			decl = decl[1:]
+		}
+	}
+
+	if len(decl) > 1 {
+		if attrMap, isMap := decl[0].(*HashMap); isMap {
+			meta = meta.Conj(attrMap).(*HashMap)
+			decl = decl[1:]
+		}
+	}
+
+	var arglists []sabre.Value
+	if len(decl) > 0 {
+		if _, isVector := formValues(decl[0]); isVector {
+			arglists = append(arglists, decl[0])
+		} else {
+			for _, method := range decl {
+				if list, isList := method.(*sabre.List); isList && list.Size() > 0 {
+					arglists = append(arglists, list.First())
+				}
+			}
+		}
+	}
+
+	meta = NewHashMap(Keyword("arglists"), &sabre.List{
+		Values: []sabre.Value{
+			sabre.Symbol{Value: "quote"}, &sabre.List{Values: arglists},
+		},
+	}).Conj(meta).(*HashMap)
+
+	for _, m := range attrs {
+		meta = meta.Conj(m).(*HashMap)
+	}
+
+	fn := append([]sabre.Value{kind, sym}, decl...)
+	return &sabre.List{
+		Values: []sabre.Value{
+			sabre.Symbol{Value: "def"},
+			&sabre.List{Values: []sabre.Value{sabre.Symbol{Value: "with-meta"}, name, meta}},
+			&sabre.List{Values: fn},
+		},
+	}, nil
+}
+
+func isWithMeta(list *sabre.List) bool {
+	if list.Size() != 3 {
+		return false
+	}
+
+	sym, isSymbol := list.First().(sabre.Symbol)
+	return isSymbol && sym.Value == "with-meta"
+}
+
+// evalMeta evaluates the metadata forms and merges them into a single map.
+// Inner forms take precedence over the outer ones.
+func evalMeta(scope sabre.Scope, forms []sabre.Value) (*HashMap, error) {
+	meta := NewHashMap()
+	for _, form := range forms {
+		v, err := form.Eval(scope)
+		if err != nil {
+			return nil, err
+		}
+
+		hm, isMap := v.(*HashMap)
+		if !isMap {
+			return nil, fmt.Errorf("metadata must be a hash-map, not %s",
+				reflect.TypeOf(v))
+		}
+
+		meta = meta.Conj(hm).(*HashMap)
+	}
+
+	return meta, nil
+}
+
+// readMeta reads ^meta form as (with-meta form meta). A keyword is
+// shorthand for {:keyword true} and a symbol for {:tag symbol}.
+func readMeta(rd *sabre.Reader, _ rune) (sabre.Value, error) {
+	pos := rd.Position()
+
+	meta, err := readMetaForm(rd)
+	if err != nil {
+		return nil, err
+	}
+
+	switch m := meta.(type) {
+	case Keyword:
+		meta = NewHashMap(m, sabre.Bool(true))
+
+	case sabre.Symbol:
+		meta = NewHashMap(Keyword("tag"), &sabre.List{
+			Values: []sabre.Value{sabre.Symbol{Value: "quote"}, m},
+		})
+
+	case *HashMap:
+
+	default:
+		return nil, fmt.Errorf("metadata must be a keyword, symbol or map, not %s",
+			reflect.TypeOf(meta))
+	}
+
+	form, err := readMetaForm(rd)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sabre.List{
+		Values:   []sabre.Value{sabre.Symbol{Value: "with-meta"}, form, meta},
+		Position: pos,
+	}, nil
+}
+
+func readMetaForm(rd *sabre.Reader) (sabre.Value, error) {
+	form, err := readForm(rd)
+	if err != nil {
+		if err == io.EOF {
+			return nil, fmt.Errorf("%w: while reading metadata", sabre.ErrEOF)
+		}
+		return nil, err
+	}
+
+	return form, nil
+}
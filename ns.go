@@ -0,0 +1,493 @@
+package xlisp
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/spy16/sabre"
+)
+
+const fileExtension = ".xlisp"
+
+// bindNS binds the functions which need access to the namespace state of
+// the interpreter.
+func (slang *Xlisp) bindNS() error {
+	fns := map[string]sabre.Value{
+		"core/ns":          sabre.ValueOf(slang.SwitchNS),
+		"core/*load-path*": loadPath(),
+		"core/require":     sabre.ValueOf(slang.require),
+		"core/all-ns":      sabre.ValueOf(slang.allNS),
+		"core/ns-name":     sabre.ValueOf(nsName),
+		"core/ns-publics":  sabre.ValueOf(slang.nsPublics),
+		"core/ns-interns":  sabre.ValueOf(slang.nsInterns),
+		"core/ns-resolve":  sabre.ValueOf(slang.nsResolve),
+		"core/ns-unmap":    sabre.ValueOf(slang.nsUnmap),
+		"core/remove-ns":   sabre.ValueOf(slang.removeNS),
+	}
+
+	for sym, val := range fns {
+		if err := slang.Bind(sym, val); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Namespaces returns the sorted names of all the namespaces which have at
+// least one binding.
+func (slang *Xlisp) Namespaces() []string {
+	slang.mu.RLock()
+	defer slang.mu.RUnlock()
+
+	seen := map[string]struct{}{}
+	var names []string
+	for sym := range slang.bindings {
+		if _, found := seen[sym.NS]; !found {
+			seen[sym.NS] = struct{}{}
+			names = append(names, sym.NS)
+		}
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// Publics returns the public bindings in the given namespace keyed by
+// their unqualified names.
+func (slang *Xlisp) Publics(ns string) map[string]sabre.Value {
+	return slang.nsVars(ns, false)
+}
+
+// Interns returns all the bindings in the given namespace, including the
+// private ones, keyed by their unqualified names.
+func (slang *Xlisp) Interns(ns string) map[string]sabre.Value {
+	return slang.nsVars(ns, true)
+}
+
+func (slang *Xlisp) nsVars(ns string, withPrivate bool) map[string]sabre.Value {
+	slang.mu.RLock()
+	defer slang.mu.RUnlock()
+
+	vars := map[string]sabre.Value{}
+	for sym, vr := range slang.bindings {
+		if sym.NS == ns && (withPrivate || !vr.Private) {
+			vars[sym.Name] = vr.Value
+		}
+	}
+
+	return vars
+}
+
+// ResolveIn resolves the symbol as if 'ns' was the current namespace. The
+// aliases and refers of 'ns' are used for resolution.
+func (slang *Xlisp) ResolveIn(ns, symbol string) (sabre.Value, error) {
+	slang.mu.RLock()
+	defer slang.mu.RUnlock()
+
+	syms, err := slang.candidates(ns, symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	return slang.resolveAny(ns, symbol, syms...)
+}
+
+// Unmap removes the binding for the unqualified name from the namespace
+// along with any refer of the same name in that namespace.
+func (slang *Xlisp) Unmap(ns, name string) {
+	slang.mu.Lock()
+	defer slang.mu.Unlock()
+
+	sym := nsSymbol{NS: ns, Name: name}
+	delete(slang.bindings, sym)
+	delete(slang.refers, sym)
+}
+
+// RemoveNS removes the namespace along with all its bindings, aliases and
+// refers. Returns false if the namespace does not exist. The core namespace
+// cannot be removed.
+func (slang *Xlisp) RemoveNS(ns string) (bool, error) {
+	if ns == "core" {
+		return false, fmt.Errorf("cannot remove core namespace")
+	}
+
+	slang.mu.Lock()
+	defer slang.mu.Unlock()
+
+	found := false
+	for sym := range slang.bindings {
+		if sym.NS == ns {
+			delete(slang.bindings, sym)
+			found = true
+		}
+	}
+
+	for sym, target := range slang.refers {
+		if sym.NS == ns || target.NS == ns {
+			delete(slang.refers, sym)
+		}
+	}
+
+	for current, aliases := range slang.aliases {
+		for alias, target := range aliases {
+			if target == ns {
+				delete(aliases, alias)
+			}
+		}
+
+		if current == ns {
+			delete(slang.aliases, current)
+		}
+	}
+
+	delete(slang.loaded, ns)
+	return found, nil
+}
+
+func (slang *Xlisp) allNS() *sabre.List {
+	var names []sabre.Value
+	for _, ns := range slang.Namespaces() {
+		names = append(names, sabre.Symbol{Value: ns})
+	}
+
+	return &sabre.List{Values: names}
+}
+
+func (slang *Xlisp) nsPublics(ns sabre.Value) (*HashMap, error) {
+	name, err := nsName(ns)
+	if err != nil {
+		return nil, err
+	}
+
+	return nsMap(slang.Publics(name.Value)), nil
+}
+
+func (slang *Xlisp) nsInterns(ns sabre.Value) (*HashMap, error) {
+	name, err := nsName(ns)
+	if err != nil {
+		return nil, err
+	}
+
+	return nsMap(slang.Interns(name.Value)), nil
+}
+
+// nsMap converts the bindings of a namespace into a hash-map with symbol
+// keys.
+func nsMap(vars map[string]sabre.Value) *HashMap {
+	hm := NewHashMap()
+	for sym, v := range vars {
+		hm = hm.Assoc(sabre.Symbol{Value: sym}, v)
+	}
+
+	return hm
+}
+
+func (slang *Xlisp) nsResolve(ns sabre.Value, sym sabre.Symbol) (sabre.Value, error) {
+	name, err := nsName(ns)
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := slang.ResolveIn(name.Value, sym.Value)
+	if err != nil {
+		return sabre.Nil{}, nil
+	}
+
+	return v, nil
+}
+
+func (slang *Xlisp) nsUnmap(ns sabre.Value, sym sabre.Symbol) error {
+	name, err := nsName(ns)
+	if err != nil {
+		return err
+	}
+
+	slang.Unmap(name.Value, sym.Value)
+	return nil
+}
+
+func (slang *Xlisp) removeNS(ns sabre.Value) (bool, error) {
+	name, err := nsName(ns)
+	if err != nil {
+		return false, err
+	}
+
+	return slang.RemoveNS(name.Value)
+}
+
+// nsName returns the name of the namespace designated by the symbol or
+// string as a symbol.
+func nsName(ns sabre.Value) (sabre.Symbol, error) {
+	switch v := ns.(type) {
+	case sabre.Symbol:
+		return sabre.Symbol{Value: v.Value}, nil
+
+	case sabre.String:
+		return sabre.Symbol{Value: string(v)}, nil
+
+	default:
+		return sabre.Symbol{}, fmt.Errorf(
+			"namespace must be a symbol or string, not %s", reflect.TypeOf(ns))
+	}
+}
+
+// loadPath returns the default load path which consists of the directories
+// listed in XLISP_PATH followed by the working directory.
+func loadPath() *Vector {
+	var dirs []sabre.Value
+	for _, dir := range filepath.SplitList(os.Getenv("XLISP_PATH")) {
+		dirs = append(dirs, sabre.String(dir))
+	}
+
+	return NewVector(append(dirs, sabre.String("."))...)
+}
+
+// requireSpec represents a parsed argument to require.
+type requireSpec struct {
+	NS       string
+	Alias    string
+	Refer    []string
+	ReferAll bool
+}
+
+// require loads the namespaces described by the given specs if they are not
+// loaded already and applies the requested aliases and refers to the current
+// namespace. A spec is either a namespace symbol or a vector of the form
+// [ns.name :as alias :refer [sym*]] where :refer also accepts :all.
+func (slang *Xlisp) require(scope sabre.Scope, specs ...sabre.Value) error {
+	for _, v := range specs {
+		spec, err := parseRequireSpec(v)
+		if err != nil {
+			return err
+		}
+
+		if err := slang.load(scope, spec.NS); err != nil {
+			return err
+		}
+
+		if err := slang.applySpec(spec); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// load evaluates the file mapped to the namespace from the load path unless
+// it has been loaded already. Namespaces which are currently being loaded are
+// tracked to detect cyclic dependencies.
+func (slang *Xlisp) load(scope sabre.Scope, ns string) error {
+	slang.mu.Lock()
+	if slang.loaded[ns] {
+		slang.mu.Unlock()
+		return nil
+	}
+
+	for i, loading := range slang.loading {
+		if loading == ns {
+			cycle := append(append([]string{}, slang.loading[i:]...), ns)
+			slang.mu.Unlock()
+			return fmt.Errorf("cyclic load dependency: %s",
+				strings.Join(cycle, " -> "))
+		}
+	}
+	slang.mu.Unlock()
+
+	file, err := locate(scope, ns)
+	if err != nil {
+		if !slang.hasNS(ns) {
+			return err
+		}
+	} else {
+		if err := slang.loadNS(scope, ns, file); err != nil {
+			return err
+		}
+	}
+
+	slang.mu.Lock()
+	slang.loaded[ns] = true
+	slang.mu.Unlock()
+
+	return nil
+}
+
+func (slang *Xlisp) loadNS(scope sabre.Scope, ns, file string) error {
+	prevNS := slang.CurrentNS()
+
+	slang.mu.Lock()
+	slang.loading = append(slang.loading, ns)
+	slang.mu.Unlock()
+
+	defer func() {
+		slang.mu.Lock()
+		slang.loading = slang.loading[:len(slang.loading)-1]
+		slang.mu.Unlock()
+	}()
+
+	_, err := LoadFile(scope, file)
+	if nsErr := slang.SwitchNS(sabre.Symbol{Value: prevNS}); err == nil {
+		err = nsErr
+	}
+
+	return err
+}
+
+func (slang *Xlisp) applySpec(spec *requireSpec) error {
+	slang.mu.Lock()
+	defer slang.mu.Unlock()
+
+	current := slang.currentNS
+
+	if spec.Alias != "" {
+		if slang.aliases[current] == nil {
+			slang.aliases[current] = map[string]string{}
+		}
+		slang.aliases[current][spec.Alias] = spec.NS
+	}
+
+	refer := spec.Refer
+	if spec.ReferAll {
+		for sym, vr := range slang.bindings {
+			if sym.NS == spec.NS && !vr.Private {
+				refer = append(refer, sym.Name)
+			}
+		}
+	}
+
+	for _, name := range refer {
+		target := nsSymbol{NS: spec.NS, Name: name}
+		vr, found := slang.bindings[target]
+		if !found {
+			return fmt.Errorf("%s does not exist in namespace %s", name, spec.NS)
+		}
+
+		if vr.Private {
+			return fmt.Errorf("%s is not public", vr)
+		}
+
+		slang.refers[nsSymbol{NS: current, Name: name}] = target
+	}
+
+	return nil
+}
+
+func (slang *Xlisp) hasNS(ns string) bool {
+	slang.mu.RLock()
+	defer slang.mu.RUnlock()
+
+	for sym := range slang.bindings {
+		if sym.NS == ns {
+			return true
+		}
+	}
+
+	return false
+}
+
+// locate finds the file for the given namespace in the directories listed
+// in *load-path*. Namespace 'foo.bar' maps to the file 'foo/bar.xlisp'.
+func locate(scope sabre.Scope, ns string) (string, error) {
+	if !hasCapability(scope, CapFilesystem) {
+		return "", &CapabilityError{Capability: CapFilesystem, Name: ns}
+	}
+
+	rel := strings.Replace(ns, ".", string(filepath.Separator), -1) + fileExtension
+
+	v, err := scope.Resolve("core/*load-path*")
+	if err != nil {
+		return "", err
+	}
+
+	dirs, ok := v.(sabre.Seq)
+	if !ok {
+		return "", fmt.Errorf("*load-path* must be a sequence, not %s",
+			reflect.TypeOf(v))
+	}
+
+	for _, v := range Realize(dirs).Values {
+		dir, isString := v.(sabre.String)
+		if !isString {
+			return "", fmt.Errorf("*load-path* entries must be strings, not %s",
+				reflect.TypeOf(v))
+		}
+
+		path := filepath.Join(string(dir), rel)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+
+	return "", fmt.Errorf("could not locate %s on load path", rel)
+}
+
+func parseRequireSpec(v sabre.Value) (*requireSpec, error) {
+	if sym, isSymbol := v.(sabre.Symbol); isSymbol {
+		return &requireSpec{NS: sym.Value}, nil
+	}
+
+	spec, isVector := formValues(v)
+	if !isVector {
+		return nil, fmt.Errorf("require spec must be a symbol or vector, not %s",
+			reflect.TypeOf(v))
+	}
+
+	if len(spec) == 0 || len(spec)%2 == 0 {
+		return nil, fmt.Errorf("invalid require spec: %s", v)
+	}
+
+	ns, isSymbol := spec[0].(sabre.Symbol)
+	if !isSymbol {
+		return nil, fmt.Errorf("namespace must be a symbol, not %s",
+			reflect.TypeOf(spec[0]))
+	}
+
+	rs := &requireSpec{NS: ns.Value}
+	for i := 1; i < len(spec); i += 2 {
+		if err := rs.setOption(spec[i], spec[i+1]); err != nil {
+			return nil, err
+		}
+	}
+
+	return rs, nil
+}
+
+func (rs *requireSpec) setOption(key, val sabre.Value) error {
+	switch key {
+	case Keyword("as"):
+		alias, isSymbol := val.(sabre.Symbol)
+		if !isSymbol {
+			return fmt.Errorf(":as expects a symbol, not %s", reflect.TypeOf(val))
+		}
+		rs.Alias = alias.Value
+
+	case Keyword("refer"):
+		if val == Keyword("all") {
+			rs.ReferAll = true
+			return nil
+		}
+
+		syms, isVector := formValues(val)
+		if !isVector {
+			return fmt.Errorf(":refer expects a vector or :all, not %s",
+				reflect.TypeOf(val))
+		}
+
+		for _, s := range syms {
+			sym, isSymbol := s.(sabre.Symbol)
+			if !isSymbol {
+				return fmt.Errorf(":refer expects symbols, not %s", reflect.TypeOf(s))
+			}
+			rs.Refer = append(rs.Refer, sym.Value)
+		}
+
+	default:
+		return fmt.Errorf("unknown require option: %s", key)
+	}
+
+	return nil
+}
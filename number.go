@@ -0,0 +1,601 @@
+package xlisp
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/spy16/sabre"
+)
+
+// BigInt is an arbitrary precision integer. BigInt literals are written
+// with an N suffix, e.g., 12345678901234567890N. Integer literals that do
+// not fit in an Int64 are read as BigInt as well.
+type BigInt struct {
+	i *big.Int
+}
+
+// NewBigInt returns a BigInt holding a copy of the integer.
+func NewBigInt(i *big.Int) BigInt {
+	return BigInt{i: new(big.Int).Set(i)}
+}
+
+// Eval returns the BigInt itself.
+func (b BigInt) Eval(_ sabre.Scope) (sabre.Value, error) { return b, nil }
+
+// Big returns a copy of the integer.
+func (b BigInt) Big() *big.Int { return new(big.Int).Set(b.i) }
+
+// Compare returns true if the other value is an integer with the same
+// value.
+func (b BigInt) Compare(other sabre.Value) bool {
+	switch o := other.(type) {
+	case BigInt:
+		return b.i.Cmp(o.i) == 0
+	case sabre.Int64:
+		return b.i.IsInt64() && b.i.Int64() == int64(o)
+	}
+	return false
+}
+
+func (b BigInt) String() string { return b.i.String() + "N" }
+
+// Ratio is an exact fraction of two integers written as 1/3. Ratios are
+// always in lowest terms and never have a denominator of 1.
+type Ratio struct {
+	r *big.Rat
+}
+
+// NewRatio returns a Ratio holding a copy of the fraction.
+func NewRatio(r *big.Rat) Ratio {
+	return Ratio{r: new(big.Rat).Set(r)}
+}
+
+// Eval returns the Ratio itself.
+func (r Ratio) Eval(_ sabre.Scope) (sabre.Value, error) { return r, nil }
+
+// Rat returns a copy of the fraction.
+func (r Ratio) Rat() *big.Rat { return new(big.Rat).Set(r.r) }
+
+// Compare returns true if the other value is a ratio with the same value.
+func (r Ratio) Compare(other sabre.Value) bool {
+	o, ok := other.(Ratio)
+	return ok && r.r.Cmp(o.r) == 0
+}
+
+func (r Ratio) String() string { return r.r.String() }
+
+// BigDecimal is an arbitrary precision decimal number with the value
+// unscaled * 10^-scale. BigDecimal literals are written with an M suffix,
+// e.g., 1.25M.
+type BigDecimal struct {
+	unscaled *big.Int
+	scale    int
+}
+
+// NewBigDecimal returns the decimal number unscaled * 10^-scale.
+func NewBigDecimal(unscaled *big.Int, scale int) BigDecimal {
+	return BigDecimal{unscaled: new(big.Int).Set(unscaled), scale: scale}
+}
+
+// Eval returns the BigDecimal itself.
+func (d BigDecimal) Eval(_ sabre.Scope) (sabre.Value, error) { return d, nil }
+
+// Rat returns the exact value of the decimal as a fraction.
+func (d BigDecimal) Rat() *big.Rat {
+	if d.scale <= 0 {
+		return new(big.Rat).SetInt(new(big.Int).Mul(d.unscaled, pow10(-d.scale)))
+	}
+	return new(big.Rat).SetFrac(d.unscaled, pow10(d.scale))
+}
+
+// Compare returns true if the other value is a decimal with the same value
+// regardless of the scale, hence 1.0M equals 1.00M.
+func (d BigDecimal) Compare(other sabre.Value) bool {
+	o, ok := other.(BigDecimal)
+	return ok && d.Rat().Cmp(o.Rat()) == 0
+}
+
+func (d BigDecimal) String() string {
+	if d.scale <= 0 {
+		return new(big.Int).Mul(d.unscaled, pow10(-d.scale)).String() + "M"
+	}
+
+	digits := new(big.Int).Abs(d.unscaled).String()
+	if len(digits) <= d.scale {
+		digits = strings.Repeat("0", d.scale-len(digits)+1) + digits
+	}
+
+	sign := ""
+	if d.unscaled.Sign() < 0 {
+		sign = "-"
+	}
+
+	point := len(digits) - d.scale
+	return sign + digits[:point] + "." + digits[point:] + "M"
+}
+
+// equal returns true if the values are equal. Unlike sabre.Compare, Int64
+// and BigInt values are equal when they hold the same integer.
+// equals implements (= x y). Unlike equal it returns the errors raised
+// while realizing the lazy sequences being compared. Sequences are only
+// realized up to the first values which differ.
+func equals(a, b sabre.Value) (bool, error) {
+	if !isLazy(a) && !isLazy(b) {
+		if va, ok := a.(*Vector); ok {
+			if vb, ok := b.(*Vector); ok {
+				return vectorEquals(va, vb)
+			}
+		}
+
+		return equal(a, b), nil
+	}
+
+	if !isSequential(a) || !isSequential(b) {
+		return false, nil
+	}
+
+	return seqValuesEqual(a.(sabre.Seq), b.(sabre.Seq))
+}
+
+// vectorEquals compares the vectors element-wise using equals.
+func vectorEquals(a, b *Vector) (bool, error) {
+	if a.Size() != b.Size() {
+		return false, nil
+	}
+
+	for i := 0; i < a.Size(); i++ {
+		eq, err := equals(a.Nth(i), b.Nth(i))
+		if err != nil || !eq {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func equal(a, b sabre.Value) bool {
+	if i, ok := a.(sabre.Int64); ok {
+		if bi, ok := b.(BigInt); ok {
+			return bi.Compare(i)
+		}
+	}
+
+	if id, ok := identityOf(a); ok {
+		other, ok := identityOf(b)
+		return ok && id == other && reflect.TypeOf(a) == reflect.TypeOf(b)
+	}
+
+	return sabre.Compare(a, b)
+}
+
+// numKind orders the numeric types by contagion: the result of an
+// operation on two numbers has the larger kind of the two.
+type numKind int
+
+const (
+	kindInt numKind = iota
+	kindBigInt
+	kindRatio
+	kindDecimal
+	kindFloat
+)
+
+func kindOf(v sabre.Value) (numKind, error) {
+	switch v.(type) {
+	case sabre.Int64:
+		return kindInt, nil
+	case BigInt:
+		return kindBigInt, nil
+	case Ratio:
+		return kindRatio, nil
+	case BigDecimal:
+		return kindDecimal, nil
+	case sabre.Float64:
+		return kindFloat, nil
+	}
+
+	return 0, notNumber(v)
+}
+
+func notNumber(v sabre.Value) error {
+	return fmt.Errorf("argument must be a number, not %s", reflect.TypeOf(v))
+}
+
+// toBig returns the value of an Int64 or BigInt as a big.Int.
+func toBig(v sabre.Value) *big.Int {
+	switch n := v.(type) {
+	case sabre.Int64:
+		return big.NewInt(int64(n))
+	case BigInt:
+		return n.i
+	}
+	return nil
+}
+
+// toRat returns the exact value of an integer, ratio or decimal.
+func toRat(v sabre.Value) *big.Rat {
+	switch n := v.(type) {
+	case Ratio:
+		return n.r
+	case BigDecimal:
+		return n.Rat()
+	}
+	return new(big.Rat).SetInt(toBig(v))
+}
+
+// toDecimal returns the value of an integer, ratio or decimal as a
+// BigDecimal. Fails for ratios without a finite decimal expansion.
+func toDecimal(v sabre.Value) (BigDecimal, error) {
+	switch n := v.(type) {
+	case BigDecimal:
+		return n, nil
+	case Ratio:
+		return ratToDecimal(n.r)
+	}
+	return BigDecimal{unscaled: toBig(v)}, nil
+}
+
+func toFloat64(v sabre.Value) float64 {
+	switch n := v.(type) {
+	case sabre.Int64:
+		return float64(n)
+	case sabre.Float64:
+		return float64(n)
+	}
+
+	f, _ := toRat(v).Float64()
+	return f
+}
+
+// ratToDecimal returns the fraction as a decimal with the smallest scale
+// that represents it exactly.
+func ratToDecimal(r *big.Rat) (BigDecimal, error) {
+	den := new(big.Int).Set(r.Denom())
+	twos, fives := 0, 0
+	for den.Bit(0) == 0 {
+		den.Rsh(den, 1)
+		twos++
+	}
+
+	five, mod := big.NewInt(5), new(big.Int)
+	for {
+		q, m := new(big.Int).QuoRem(den, five, mod)
+		if m.Sign() != 0 {
+			break
+		}
+		den = q
+		fives++
+	}
+
+	if den.Cmp(big.NewInt(1)) != 0 {
+		return BigDecimal{}, fmt.Errorf("non-terminating decimal expansion; " +
+			"no exact representable decimal result")
+	}
+
+	scale := twos
+	if fives > scale {
+		scale = fives
+	}
+
+	unscaled := new(big.Int).Mul(r.Num(), pow10(scale))
+	unscaled.Quo(unscaled, r.Denom())
+	return BigDecimal{unscaled: unscaled, scale: scale}, nil
+}
+
+// normalizeRat returns the fraction as a Ratio unless it is a whole number,
+// in which case an Int64 is returned if it fits and small is set, a BigInt
+// otherwise.
+func normalizeRat(r *big.Rat, small bool) sabre.Value {
+	if !r.IsInt() {
+		return Ratio{r: r}
+	}
+
+	return normalizeInt(r.Num(), small)
+}
+
+func normalizeInt(i *big.Int, small bool) sabre.Value {
+	if small && i.IsInt64() {
+		return sabre.Int64(i.Int64())
+	}
+	return BigInt{i: i}
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+// toInt implements (int x), truncating ratios, decimals and floats.
+func toInt(v sabre.Value) (sabre.Value, error) {
+	var i *big.Int
+	switch n := v.(type) {
+	case sabre.Int64:
+		return n, nil
+
+	case sabre.Float64:
+		if math.IsNaN(float64(n)) || math.IsInf(float64(n), 0) {
+			return nil, fmt.Errorf("cannot convert %v to int", n)
+		}
+		i, _ = big.NewFloat(float64(n)).Int(nil)
+
+	case BigInt:
+		i = n.i
+
+	case Ratio, BigDecimal:
+		r := toRat(n)
+		i = new(big.Int).Quo(r.Num(), r.Denom())
+
+	default:
+		return ToType(sabre.Type{T: reflect.TypeOf(sabre.Int64(0))}, v)
+	}
+
+	if !i.IsInt64() {
+		return nil, fmt.Errorf("value out of range for int: %s", i)
+	}
+	return sabre.Int64(i.Int64()), nil
+}
+
+// toFloat implements (float x).
+func toFloat(v sabre.Value) (sabre.Value, error) {
+	if _, err := kindOf(v); err != nil {
+		return ToType(sabre.Type{T: reflect.TypeOf(sabre.Float64(0))}, v)
+	}
+	return sabre.Float64(toFloat64(v)), nil
+}
+
+// toBigInt implements (bigint x), truncating ratios, decimals and floats.
+func toBigInt(v sabre.Value) (sabre.Value, error) {
+	switch n := v.(type) {
+	case sabre.Int64, BigInt:
+		return BigInt{i: toBig(n)}, nil
+
+	case sabre.Float64:
+		if math.IsNaN(float64(n)) || math.IsInf(float64(n), 0) {
+			return nil, fmt.Errorf("cannot convert %v to bigint", n)
+		}
+		i, _ := big.NewFloat(float64(n)).Int(nil)
+		return BigInt{i: i}, nil
+
+	case Ratio, BigDecimal:
+		r := toRat(n)
+		return BigInt{i: new(big.Int).Quo(r.Num(), r.Denom())}, nil
+	}
+
+	return nil, notNumber(v)
+}
+
+// toBigDecimal implements (bigdec x).
+func toBigDecimal(v sabre.Value) (sabre.Value, error) {
+	kind, err := kindOf(v)
+	if err != nil {
+		return nil, err
+	}
+
+	if kind == kindFloat {
+		return parseDecimal(strconv.FormatFloat(toFloat64(v), 'g', -1, 64))
+	}
+	return toDecimal(v)
+}
+
+// numerator implements (numerator r).
+func numerator(r Ratio) BigInt { return BigInt{i: new(big.Int).Set(r.r.Num())} }
+
+// denominator implements (denominator r).
+func denominator(r Ratio) BigInt { return BigInt{i: new(big.Int).Set(r.r.Denom())} }
+
+// startsNumber returns true if the rune, followed by the next rune in the
+// stream, starts a number literal.
+func startsNumber(rd *sabre.Reader, init rune) (bool, error) {
+	if unicode.IsNumber(init) {
+		return true, nil
+	}
+
+	if init != '+' && init != '-' {
+		return false, nil
+	}
+
+	r, err := rd.NextRune()
+	if err != nil {
+		if err == io.EOF {
+			return false, nil
+		}
+		return false, err
+	}
+	rd.Unread(r)
+
+	return unicode.IsNumber(r), nil
+}
+
+// readNumber reads a number literal. In addition to the formats supported
+// by sabre, BigInt (1N), BigDecimal (1.5M) and Ratio (1/3) literals are
+// read.
+func readNumber(rd *sabre.Reader, init rune) (sabre.Value, error) {
+	var b strings.Builder
+	b.WriteRune(init)
+	for {
+		r, err := rd.NextRune()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+
+		if rd.IsTerminal(r) {
+			rd.Unread(r)
+			break
+		}
+		b.WriteRune(r)
+	}
+
+	return parseNumber(b.String())
+}
+
+func parseNumber(s string) (sabre.Value, error) {
+	digits := strings.TrimLeft(s, "+-")
+	isHex := strings.HasPrefix(digits, "0x") || strings.HasPrefix(digits, "0X")
+
+	switch {
+	case strings.HasSuffix(s, "N"):
+		i, ok := new(big.Int).SetString(strings.TrimPrefix(s[:len(s)-1], "+"), 0)
+		if !ok {
+			break
+		}
+		return BigInt{i: i}, nil
+
+	case strings.HasSuffix(s, "M"):
+		return parseDecimal(s[:len(s)-1])
+
+	case strings.ContainsRune(s, '/'):
+		return parseRatio(s)
+
+	case strings.ContainsRune(s, 'r'):
+		return parseRadix(s)
+
+	case !isHex && strings.ContainsAny(s, ".eE"):
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			break
+		}
+		return sabre.Float64(f), nil
+
+	default:
+		i, err := strconv.ParseInt(s, 0, 64)
+		if err == nil {
+			return sabre.Int64(i), nil
+		}
+
+		// integers too large for an Int64 are read as BigInt.
+		if bi, ok := new(big.Int).SetString(strings.TrimPrefix(s, "+"), 0); ok {
+			return BigInt{i: bi}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("illegal number format '%s'", s)
+}
+
+func parseRatio(s string) (sabre.Value, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("illegal ratio format '%s'", s)
+	}
+
+	num, ok := new(big.Int).SetString(strings.TrimPrefix(parts[0], "+"), 10)
+	if !ok {
+		return nil, fmt.Errorf("illegal ratio format '%s'", s)
+	}
+
+	den, ok := new(big.Int).SetString(parts[1], 10)
+	if !ok || strings.HasPrefix(parts[1], "-") || strings.HasPrefix(parts[1], "+") {
+		return nil, fmt.Errorf("illegal ratio format '%s'", s)
+	}
+
+	if den.Sign() == 0 {
+		return nil, fmt.Errorf("divide by zero in ratio '%s'", s)
+	}
+
+	return normalizeRat(new(big.Rat).SetFrac(num, den), true), nil
+}
+
+func parseRadix(s string) (sabre.Value, error) {
+	parts := strings.Split(s, "r")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("illegal radix notation '%s'", s)
+	}
+
+	base, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("illegal radix notation '%s'", s)
+	}
+
+	repr := parts[1]
+	if base < 0 {
+		base = -base
+		repr = "-" + repr
+	}
+
+	if base < 2 || base > 36 {
+		return nil, fmt.Errorf("illegal radix notation '%s'", s)
+	}
+
+	i, ok := new(big.Int).SetString(repr, int(base))
+	if !ok {
+		return nil, fmt.Errorf("illegal radix notation '%s'", s)
+	}
+
+	return normalizeInt(i, true), nil
+}
+
+// parseDecimal parses a decimal number such as -1.25 or 1.5e3 exactly.
+func parseDecimal(s string) (BigDecimal, error) {
+	mantissa, exp := s, 0
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		e, err := strconv.Atoi(s[i+1:])
+		if err != nil {
+			return BigDecimal{}, fmt.Errorf("illegal decimal format '%sM'", s)
+		}
+		mantissa, exp = s[:i], e
+	}
+
+	scale := 0
+	if i := strings.IndexRune(mantissa, '.'); i >= 0 {
+		scale = len(mantissa) - i - 1
+		mantissa = mantissa[:i] + mantissa[i+1:]
+	}
+
+	unscaled, ok := new(big.Int).SetString(strings.TrimPrefix(mantissa, "+"), 10)
+	if !ok {
+		return BigDecimal{}, fmt.Errorf("illegal decimal format '%sM'", s)
+	}
+
+	return BigDecimal{unscaled: unscaled, scale: scale - exp}, nil
+}
+
+// numCompare returns -1, 0 or 1 if a is less than, equal to or greater
+// than b. Numbers of different kinds are compared by value, hence 1 and
+// 1.0 compare equal. NaN compares equal to every number.
+func numCompare(a, b sabre.Value) (int, error) {
+	ka, err := kindOf(a)
+	if err != nil {
+		return 0, err
+	}
+
+	kb, err := kindOf(b)
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case ka == kindInt && kb == kindInt:
+		x, y := a.(sabre.Int64), b.(sabre.Int64)
+		switch {
+		case x < y:
+			return -1, nil
+		case x > y:
+			return 1, nil
+		}
+		return 0, nil
+
+	case ka == kindFloat || kb == kindFloat:
+		x, y := toFloat64(a), toFloat64(b)
+		switch {
+		case x < y:
+			return -1, nil
+		case x > y:
+			return 1, nil
+		}
+		return 0, nil
+	}
+
+	return toRat(a).Cmp(toRat(b)), nil
+}
+
+func isNaN(v sabre.Value) bool {
+	f, ok := v.(sabre.Float64)
+	return ok && math.IsNaN(float64(f))
+}
@@ -0,0 +1,95 @@
+package xlisp
+
+import (
+	"io"
+	"math/rand"
+)
+
+// Option configures an interpreter created by New.
+type Option func(slang *Xlisp)
+
+// WithCapabilities sets the privileged operations scripts are allowed to
+// perform. Interpreters are granted CapAll by default. Bindings requiring
+// a capability which is not granted fail with CapabilityError.
+func WithCapabilities(caps Capability) Option {
+	return func(slang *Xlisp) {
+		slang.caps = caps
+	}
+}
+
+// WithInteropAllowList adds members of Go values which can be accessed
+// without CapInterop in addition to DefaultInteropAllowList.
+func WithInteropAllowList(members ...string) Option {
+	return func(slang *Xlisp) {
+		for _, member := range members {
+			slang.interopAllowed[member] = true
+		}
+	}
+}
+
+// WithNamespace sets the namespace which is current once the interpreter
+// is created. Defaults to user.
+func WithNamespace(ns string) Option {
+	return func(slang *Xlisp) {
+		slang.initialNS = ns
+	}
+}
+
+// WithCoreLibrary replaces the embedded core library with the source read
+// from 'r'. The core library is evaluated when the interpreter is created,
+// after all the builtins are bound.
+func WithCoreLibrary(r io.Reader) Option {
+	return func(slang *Xlisp) {
+		slang.coreLib = r
+	}
+}
+
+// WithoutCoreLibrary creates the interpreter with only the builtins
+// implemented in Go.
+func WithoutCoreLibrary() Option {
+	return func(slang *Xlisp) {
+		slang.coreLib = nil
+	}
+}
+
+// WithBindings binds the Go values to the symbols in addition to the
+// builtins. Unqualified symbols are bound in the initial namespace.
+func WithBindings(bindings map[string]interface{}) Option {
+	return func(slang *Xlisp) {
+		for sym, v := range bindings {
+			slang.goBindings[sym] = v
+		}
+	}
+}
+
+// WithStdin sets the reader bound to *in*, the standard input used by read.
+func WithStdin(r io.Reader) Option {
+	return func(slang *Xlisp) {
+		slang.stdin = r
+	}
+}
+
+// WithStdout sets the writer bound to *out*, the standard output used by
+// print, printf and the prompt of read.
+func WithStdout(w io.Writer) Option {
+	return func(slang *Xlisp) {
+		slang.stdout = w
+	}
+}
+
+// WithStderr sets the writer bound to *err*, the standard error used by
+// eprint and eprintf.
+func WithStderr(w io.Writer) Option {
+	return func(slang *Xlisp) {
+		slang.stderr = w
+	}
+}
+
+// WithRandom sets the source of random numbers used by random and
+// shuffle. A source seeded with the time of process start is used by
+// default.
+func WithRandom(rnd *rand.Rand) Option {
+	return func(slang *Xlisp) {
+		slang.random = rnd
+	}
+}
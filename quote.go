@@ -0,0 +1,104 @@
+package xlisp
+
+import (
+	"github.com/spy16/sabre"
+)
+
+// SyntaxQuote quotes a form like quote but evaluates the forms inside it
+// marked with unquote, e.g. `(let [x ~v] x). Unlike the sabre syntax-quote,
+// the persistent collections read from vector, hash-map and set literals
+// are quoted as collections of the same type.
+var SyntaxQuote = sabre.SpecialForm{
+	Name:  "syntax-quote",
+	Parse: parseSyntaxQuote,
+}
+
+func parseSyntaxQuote(scope sabre.Scope, forms []sabre.Value) (*sabre.Fn, error) {
+	if err := verifyArgCount([]int{1}, forms); err != nil {
+		return nil, err
+	}
+
+	return &sabre.Fn{
+		Func: func(scope sabre.Scope, _ []sabre.Value) (sabre.Value, error) {
+			return syntaxQuote(scope, forms[0])
+		},
+	}, nil
+}
+
+func syntaxQuote(scope sabre.Scope, form sabre.Value) (sabre.Value, error) {
+	switch f := form.(type) {
+	case *sabre.List:
+		if f.Size() > 0 && isSymbolNamed(f.First(), "unquote") {
+			if err := verifyArgCount([]int{1}, f.Values[1:]); err != nil {
+				return nil, err
+			}
+
+			return f.Values[1].Eval(scope)
+		}
+
+		quoted, err := syntaxQuoteAll(scope, f.Values)
+		if err != nil {
+			return nil, err
+		}
+		return &sabre.List{Values: quoted, Position: f.Position}, nil
+
+	case *Vector:
+		quoted, err := syntaxQuoteAll(scope, f.Values())
+		if err != nil {
+			return nil, err
+		}
+
+		vec := NewVector(quoted...)
+		vec.Position = f.Position
+		return vec, nil
+
+	case *Set:
+		quoted, err := syntaxQuoteAll(scope, f.Values())
+		if err != nil {
+			return nil, err
+		}
+
+		set := NewSet(quoted...)
+		set.Position = f.Position
+		return set, nil
+
+	case *HashMap:
+		var kvs []sabre.Value
+		f.each(func(k, v sabre.Value) bool {
+			kvs = append(kvs, k, v)
+			return true
+		})
+
+		quoted, err := syntaxQuoteAll(scope, kvs)
+		if err != nil {
+			return nil, err
+		}
+
+		hm := NewHashMap(quoted...)
+		hm.Position = f.Position
+		return hm, nil
+
+	case sabre.Vector:
+		quoted, err := syntaxQuoteAll(scope, f.Values)
+		return sabre.Vector{Values: quoted, Position: f.Position}, err
+
+	case sabre.Set:
+		quoted, err := syntaxQuoteAll(scope, f.Values)
+		return sabre.Set{Values: quoted, Position: f.Position}, err
+	}
+
+	return form, nil
+}
+
+func syntaxQuoteAll(scope sabre.Scope, forms []sabre.Value) ([]sabre.Value, error) {
+	quoted := make([]sabre.Value, len(forms))
+	for i, form := range forms {
+		q, err := syntaxQuote(scope, form)
+		if err != nil {
+			return nil, err
+		}
+		quoted[i] = q
+	}
+
+	return quoted, nil
+}
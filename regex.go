@@ -0,0 +1,204 @@
+package xlisp
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/spy16/sabre"
+)
+
+// Regex is a compiled regular expression read from a #"..." literal or
+// created with re-pattern. The syntax is the one of the Go regexp package.
+type Regex struct {
+	*regexp.Regexp
+
+	// anchored matches the whole input only, for re-matches.
+	anchored *regexp.Regexp
+}
+
+// NewRegex compiles the pattern into a Regex.
+func NewRegex(pattern string) (*Regex, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	anchored, err := regexp.Compile(`^(?:` + pattern + `)$`)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Regex{Regexp: re, anchored: anchored}, nil
+}
+
+// Eval returns the regex itself.
+func (re *Regex) Eval(_ sabre.Scope) (sabre.Value, error) { return re, nil }
+
+func (re *Regex) String() string {
+	return `#"` + re.Regexp.String() + `"`
+}
+
+// readRegex reads a #"..." literal. Unlike string literals, backslashes
+// are kept as they are so that patterns such as #"\d+" need no double
+// escaping. Only \" is needed to match a double quote.
+func readRegex(rd *sabre.Reader, _ rune) (sabre.Value, error) {
+	var sb strings.Builder
+	for {
+		r, err := rd.NextRune()
+		if err != nil {
+			if err == io.EOF {
+				return nil, fmt.Errorf("EOF while reading regex")
+			}
+			return nil, err
+		}
+
+		if r == '"' {
+			break
+		}
+
+		sb.WriteRune(r)
+		if r == '\\' {
+			next, err := rd.NextRune()
+			if err != nil {
+				if err == io.EOF {
+					return nil, fmt.Errorf("EOF while reading regex")
+				}
+				return nil, err
+			}
+			sb.WriteRune(next)
+		}
+	}
+
+	return NewRegex(sb.String())
+}
+
+// rePattern implements (re-pattern s).
+func rePattern(v sabre.Value) (*Regex, error) {
+	switch p := v.(type) {
+	case *Regex:
+		return p, nil
+
+	case sabre.String:
+		return NewRegex(string(p))
+	}
+
+	return nil, fmt.Errorf("pattern must be a string, not %s", reflect.TypeOf(v))
+}
+
+// reFind implements (re-find re s) which returns the first match of the
+// regex in the string, or nil if there is none. Matches of regexes with
+// groups are returned as vectors of the whole match followed by the
+// groups, with nil for the groups that did not participate in the match.
+func reFind(re *Regex, s string) sabre.Value {
+	loc := re.FindStringSubmatchIndex(s)
+	if loc == nil {
+		return sabre.Nil{}
+	}
+
+	return matchValue(s, loc)
+}
+
+// reMatches implements (re-matches re s) which is like re-find but matches
+// only if the whole string matches the regex.
+func reMatches(re *Regex, s string) sabre.Value {
+	loc := re.anchored.FindStringSubmatchIndex(s)
+	if loc == nil {
+		return sabre.Nil{}
+	}
+
+	return matchValue(s, loc)
+}
+
+// reSeq implements (re-seq re s) which returns a list of the successive
+// matches of the regex in the string, each of them like re-find returns.
+func reSeq(re *Regex, s string) *sabre.List {
+	matches := re.FindAllStringSubmatchIndex(s, -1)
+	values := make([]sabre.Value, len(matches))
+	for i, loc := range matches {
+		values[i] = matchValue(s, loc)
+	}
+
+	return &sabre.List{Values: values}
+}
+
+// reGroups implements (re-groups re s) which returns the groups of the
+// first match of the regex in the string as a map, or nil if there is no
+// match. Named groups such as (?P<year>\d+) are keyed by keywords like
+// :year and the other groups by their index.
+func reGroups(re *Regex, s string) sabre.Value {
+	loc := re.FindStringSubmatchIndex(s)
+	if loc == nil {
+		return sabre.Nil{}
+	}
+
+	t := NewHashMap().Transient()
+	for i, name := range re.SubexpNames() {
+		if i == 0 {
+			continue
+		}
+
+		var key sabre.Value = sabre.Int64(i)
+		if name != "" {
+			key = Keyword(name)
+		}
+		t.assoc(key, groupValue(s, loc, i))
+	}
+
+	return t.persistent()
+}
+
+// replaceRegex replaces the matches of the regex in the string. The
+// replacement is either a string, which may refer to groups with $1 or
+// ${name}, or a function called with each match, like re-find returns it,
+// and returning the string replacing it.
+func replaceRegex(scope sabre.Scope, s string, re *Regex, replacement sabre.Value) (string, error) {
+	switch r := replacement.(type) {
+	case sabre.String:
+		return re.ReplaceAllString(s, string(r)), nil
+
+	case sabre.Invokable:
+		var sb strings.Builder
+		last := 0
+		for _, loc := range re.FindAllStringSubmatchIndex(s, -1) {
+			res, err := invoke(scope, r, matchValue(s, loc))
+			if err != nil {
+				return "", err
+			}
+
+			sb.WriteString(s[last:loc[0]])
+			sb.WriteString(string(MakeString(res).(sabre.String)))
+			last = loc[1]
+		}
+
+		sb.WriteString(s[last:])
+		return sb.String(), nil
+	}
+
+	return "", fmt.Errorf("replacement must be a string or a function, not %s",
+		reflect.TypeOf(replacement))
+}
+
+// matchValue returns the match located by loc as a string, or as a vector
+// of the match followed by its groups if the regex has groups.
+func matchValue(s string, loc []int) sabre.Value {
+	if len(loc) == 2 {
+		return sabre.String(s[loc[0]:loc[1]])
+	}
+
+	vals := make([]sabre.Value, len(loc)/2)
+	for i := range vals {
+		vals[i] = groupValue(s, loc, i)
+	}
+	return NewVector(vals...)
+}
+
+func groupValue(s string, loc []int, i int) sabre.Value {
+	if loc[2*i] < 0 {
+		return sabre.Nil{}
+	}
+
+	return sabre.String(s[loc[2*i]:loc[2*i+1]])
+}
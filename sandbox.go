@@ -0,0 +1,197 @@
+package xlisp
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spy16/sabre"
+)
+
+// Capability is a set of privileged operations which scripts evaluated by
+// an interpreter may perform. Capabilities are granted through the
+// WithCapabilities option of New.
+type Capability uint
+
+// Capabilities that can be granted to an interpreter.
+const (
+	// CapShell allows running shell commands through $.
+	CapShell Capability = 1 << iota
+
+	// CapFilesystem allows reading files and loading source files through
+	// read-file, load-file and require.
+	CapFilesystem
+
+	// CapProcess allows access to the terminal and the standard input of
+	// the interpreter through *in* and the tview namespace. Without it the
+	// root binding of *in* fails every read but streams bound locally,
+	// e.g. through with-in-str, can still be read.
+	CapProcess
+
+	// CapInterop allows access to any member of Go values through symbols
+	// such as x.Method. Without it only the members in the interop
+	// allow-list can be accessed.
+	CapInterop
+
+	// CapAll grants all the capabilities.
+	CapAll = CapShell | CapFilesystem | CapProcess | CapInterop
+)
+
+var capabilityNames = []string{"shell", "filesystem", "process", "interop"}
+
+// DefaultInteropAllowList contains the members which can be accessed
+// without CapInterop. These are the members of the sequence and atom
+// types used by the core library.
+var DefaultInteropAllowList = []string{
+	"Conj", "Cons", "First", "GetVal", "Next", "Size",
+}
+
+// gatedSymbols lists the core bindings which require a capability.
+var gatedSymbols = map[Capability][]string{
+	CapShell:      {"core/$"},
+	CapFilesystem: {"core/read-file", "core/load-file"},
+}
+
+func (c Capability) String() string {
+	var names []string
+	for i, name := range capabilityNames {
+		if c&(1<<uint(i)) != 0 {
+			names = append(names, name)
+		}
+	}
+
+	if len(names) == 0 {
+		return "none"
+	}
+
+	return strings.Join(names, "|")
+}
+
+// CapabilityError is returned when a script attempts an operation which
+// requires a capability that has not been granted to the interpreter.
+type CapabilityError struct {
+	Capability Capability
+	Name       string
+}
+
+func (e *CapabilityError) Error() string {
+	return fmt.Sprintf("%s: %s access is not permitted", e.Name, e.Capability)
+}
+
+// restrict replaces the bindings which require capabilities that are not
+// granted with functions failing with CapabilityError.
+func (slang *Xlisp) restrict() error {
+	for c, syms := range gatedSymbols {
+		if slang.caps&c != 0 {
+			continue
+		}
+
+		for _, sym := range syms {
+			if err := slang.Bind(sym, denied(c, sym)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if slang.caps&CapProcess == 0 {
+		if err := slang.Bind("core/"+symIn, newInStream(deniedReader{})); err != nil {
+			return err
+		}
+
+		for name := range slang.Publics("tview") {
+			sym := "tview/" + name
+			if err := slang.Bind(sym, denied(CapProcess, sym)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkInterop returns an error if the form contains a symbol accessing
+// a member of a Go value which is not permitted. Forms are checked before
+// they are evaluated, which covers the source read by the interpreter, the
+// forms given to eval and the expansions of macros.
+func (slang *Xlisp) checkInterop(form sabre.Value) error {
+	if slang.caps&CapInterop != 0 {
+		return nil
+	}
+
+	switch f := form.(type) {
+	case sabre.Symbol:
+		if f.Value == "." || !strings.Contains(f.Value, ".") {
+			return nil
+		}
+
+		for _, member := range strings.Split(f.Value, ".")[1:] {
+			if !slang.interopAllowed[member] {
+				return &CapabilityError{Capability: CapInterop, Name: f.Value}
+			}
+		}
+
+	case sabre.Module:
+		return slang.checkInteropAll(f)
+
+	case *sabre.List:
+		return slang.checkInteropAll(f.Values)
+
+	case sabre.Vector:
+		return slang.checkInteropAll(f.Values)
+
+	case sabre.Set:
+		return slang.checkInteropAll(f.Values)
+
+	case *Vector:
+		return slang.checkInteropAll(f.Values())
+
+	case *Set:
+		return slang.checkInteropAll(f.Values())
+
+	case *HashMap:
+		var err error
+		f.each(func(k, v sabre.Value) bool {
+			err = slang.checkInteropAll([]sabre.Value{k, v})
+			return err == nil
+		})
+		return err
+	}
+
+	return nil
+}
+
+func (slang *Xlisp) checkInteropAll(forms []sabre.Value) error {
+	for _, form := range forms {
+		if err := slang.checkInterop(form); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkForm checks the form against the capabilities of the interpreter
+// owning the scope before it is evaluated.
+func checkForm(scope sabre.Scope, form sabre.Value) error {
+	xl := interpreter(scope)
+	if xl == nil {
+		return nil
+	}
+
+	return xl.checkInterop(form)
+}
+
+// hasCapability returns true if the interpreter owning the scope has been
+// granted the capability.
+func hasCapability(scope sabre.Scope, c Capability) bool {
+	xl := interpreter(scope)
+	return xl == nil || xl.caps&c != 0
+}
+
+func denied(c Capability, sym string) *sabre.Fn {
+	return &sabre.Fn{
+		Variadic: true,
+		Func: func(_ sabre.Scope, _ []sabre.Value) (sabre.Value, error) {
+			return nil, &CapabilityError{Capability: c, Name: sym}
+		},
+	}
+}
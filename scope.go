@@ -0,0 +1,177 @@
+package xlisp
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/spy16/sabre"
+)
+
+// localScope holds local bindings such as function arguments. Scopes of
+// function invocations additionally record the namespace the function was
+// defined in so that global symbols used in the function body resolve in
+// that namespace instead of the namespace active at the time of the call.
+type localScope struct {
+	parent   sabre.Scope
+	ns       string
+	depth    int
+	state    *evalState
+	mu       sync.RWMutex
+	bindings map[string]sabre.Value
+	dynamic  map[*Var]sabre.Value
+}
+
+// newLocalScope returns a scope for local bindings such as the ones created
+// by let and loop.
+func newLocalScope(parent sabre.Scope) *localScope {
+	return &localScope{
+		parent:   parent,
+		depth:    depthOf(parent),
+		state:    stateOf(parent),
+		bindings: map[string]sabre.Value{},
+	}
+}
+
+// newFnScope returns a scope for the invocation of a function defined in
+// namespace 'ns'.
+func newFnScope(parent sabre.Scope, ns string) *localScope {
+	s := newLocalScope(parent)
+	s.ns = ns
+	s.depth++
+	return s
+}
+
+// check returns an error if the evaluation the scope belongs to has been
+// cancelled or has exceeded its limits.
+func (s *localScope) check() error {
+	if s.state == nil {
+		return nil
+	}
+
+	return s.state.check(s.depth)
+}
+
+// Parent returns the parent scope of this scope.
+func (s *localScope) Parent() sabre.Scope { return s.parent }
+
+// Bind binds the symbol to the given value in this scope.
+func (s *localScope) Bind(symbol string, v sabre.Value) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.bindings[symbol] = v
+	return nil
+}
+
+// Resolve finds the value bound to the symbol in this scope or in any of
+// the parent scopes.
+func (s *localScope) Resolve(symbol string) (sabre.Value, error) {
+	return resolveIn(s, "", symbol)
+}
+
+func (s *localScope) lookup(symbol string) (sabre.Value, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	v, found := s.bindings[symbol]
+	return v, found
+}
+
+// assign rebinds the symbol in the innermost local scope it is bound in
+// and returns false if it is not bound locally.
+func assign(scope sabre.Scope, symbol string, v sabre.Value) bool {
+	for ; scope != nil; scope = scope.Parent() {
+		s, ok := scope.(*localScope)
+		if !ok {
+			continue
+		}
+
+		if _, found := s.lookup(symbol); found {
+			_ = s.Bind(symbol, v)
+			return true
+		}
+	}
+
+	return false
+}
+
+// resolveIn resolves the symbol starting from the given scope. Once the
+// root scope is reached, global symbols are resolved in namespace 'ns' or,
+// if 'ns' is empty, in the namespace of the innermost function being
+// invoked. Dynamic vars resolve to the value of the innermost binding form
+// enclosing the scope, if any.
+func resolveIn(scope sabre.Scope, ns, symbol string) (sabre.Value, error) {
+	for cur := scope; cur != nil; {
+		switch s := cur.(type) {
+		case *localScope:
+			if v, found := s.lookup(symbol); found {
+				return v, nil
+			}
+
+			if ns == "" {
+				ns = s.ns
+			}
+			cur = s.parent
+
+		case *evalScope:
+			cur = s.xl
+
+		case *Xlisp:
+			if ns == "" {
+				ns = s.CurrentNS()
+			}
+
+			vr, v, err := s.resolveVar(ns, symbol)
+			if err != nil {
+				return nil, err
+			}
+
+			if vr.Dynamic {
+				if bound, found := dynamicValue(scope, vr); found {
+					return bound, nil
+				}
+			}
+			return v, nil
+
+		default:
+			return cur.Resolve(symbol)
+		}
+	}
+
+	return nil, fmt.Errorf("unable to resolve symbol: %v", symbol)
+}
+
+// rootScope returns the outermost scope of the given scope.
+func rootScope(scope sabre.Scope) sabre.Scope {
+	for scope.Parent() != nil {
+		scope = scope.Parent()
+	}
+
+	return scope
+}
+
+// interpreter returns the interpreter owning the scope or nil if the root
+// scope is not an interpreter.
+func interpreter(scope sabre.Scope) *Xlisp {
+	xl, _ := rootScope(scope).(*Xlisp)
+	return xl
+}
+
+// scopeNS returns the namespace in which global symbols are resolved from
+// the given scope. This is the namespace of the innermost function being
+// invoked or the current namespace of the interpreter at the top level.
+func scopeNS(scope sabre.Scope) string {
+	for ; scope != nil; scope = scope.Parent() {
+		switch s := scope.(type) {
+		case *localScope:
+			if s.ns != "" {
+				return s.ns
+			}
+
+		case *Xlisp:
+			return s.CurrentNS()
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,533 @@
+package xlisp
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/spy16/sabre"
+)
+
+// sortSeq implements (sort coll) and (sort comp coll). The sort is stable.
+// Without a comparator the values are ordered using compare.
+func sortSeq(scope sabre.Scope, args ...sabre.Value) (sabre.Value, error) {
+	if err := verifyArgCount([]int{1, 2}, args); err != nil {
+		return nil, err
+	}
+
+	cmp, err := comparator(scope, args[:len(args)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	vals, err := seqValues(args[len(args)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	if err := stableSort(vals, nil, cmp); err != nil {
+		return nil, err
+	}
+
+	return &sabre.List{Values: vals}, nil
+}
+
+// sortBy implements (sort-by keyfn coll) and (sort-by keyfn comp coll).
+// The key of each value is computed only once.
+func sortBy(scope sabre.Scope, keyFn sabre.Invokable, args ...sabre.Value) (sabre.Value, error) {
+	if err := verifyArgCount([]int{1, 2}, args); err != nil {
+		return nil, err
+	}
+
+	cmp, err := comparator(scope, args[:len(args)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	vals, err := seqValues(args[len(args)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]sabre.Value, len(vals))
+	for i, v := range vals {
+		if keys[i], err = invoke(scope, keyFn, v); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := stableSort(vals, keys, cmp); err != nil {
+		return nil, err
+	}
+
+	return &sabre.List{Values: vals}, nil
+}
+
+// stableSort sorts the values by their keys, keeping values with equal
+// keys in their original order. The keys are reordered along with the
+// values. The values are sorted by themselves if keys is nil.
+func stableSort(vals, keys []sabre.Value, cmp func(a, b sabre.Value) (int, error)) error {
+	var sortErr error
+	sort.Stable(&keyedValues{
+		vals: vals,
+		keys: keys,
+		less: func(a, b sabre.Value) bool {
+			if sortErr != nil {
+				return false
+			}
+
+			c, err := cmp(a, b)
+			if err != nil {
+				sortErr = err
+			}
+			return c < 0
+		},
+	})
+
+	return sortErr
+}
+
+type keyedValues struct {
+	vals, keys []sabre.Value
+	less       func(a, b sabre.Value) bool
+}
+
+func (kv *keyedValues) Len() int { return len(kv.vals) }
+
+func (kv *keyedValues) Less(i, j int) bool {
+	if kv.keys == nil {
+		return kv.less(kv.vals[i], kv.vals[j])
+	}
+	return kv.less(kv.keys[i], kv.keys[j])
+}
+
+func (kv *keyedValues) Swap(i, j int) {
+	kv.vals[i], kv.vals[j] = kv.vals[j], kv.vals[i]
+	if kv.keys != nil {
+		kv.keys[i], kv.keys[j] = kv.keys[j], kv.keys[i]
+	}
+}
+
+// comparator returns the comparison function given as the optional
+// argument or compare. A comparator may return a number, like compare, or
+// a boolean which is true if the first argument is less than the second,
+// like <.
+func comparator(scope sabre.Scope, args []sabre.Value) (func(a, b sabre.Value) (int, error), error) {
+	if len(args) == 0 {
+		return compare, nil
+	}
+
+	f, ok := args[0].(sabre.Invokable)
+	if !ok {
+		return nil, fmt.Errorf("comparator must be a function, not %s",
+			reflect.TypeOf(args[0]))
+	}
+
+	return func(a, b sabre.Value) (int, error) {
+		res, err := invoke(scope, f, a, b)
+		if err != nil {
+			return 0, err
+		}
+
+		if less, isBool := res.(sabre.Bool); isBool {
+			if less {
+				return -1, nil
+			}
+
+			res, err = invoke(scope, f, b, a)
+			if err != nil || !isTruthy(res) {
+				return 0, err
+			}
+			return 1, nil
+		}
+
+		if _, err := kindOf(res); err != nil {
+			return 0, fmt.Errorf("comparator must return a number or a boolean, not %s",
+				reflect.TypeOf(res))
+		}
+		return numCompare(res, sabre.Int64(0))
+	}, nil
+}
+
+// groupBy implements (group-by f coll) which returns a map from the results
+// of f to vectors of the values producing them, in their original order.
+func groupBy(scope sabre.Scope, f sabre.Invokable, coll sabre.Value) (*HashMap, error) {
+	groups := NewHashMap().Transient()
+	err := forEach(coll, func(v sabre.Value) error {
+		key, err := invoke(scope, f, v)
+		if err != nil {
+			return err
+		}
+
+		group, found := groups.Lookup(key)
+		if !found {
+			group = NewVector().Transient()
+			groups.assoc(key, group)
+		}
+		group.(*TransientVector).conj(v)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res := groups.persistent()
+	t := res.Transient()
+	res.each(func(key, group sabre.Value) bool {
+		t.assoc(key, group.(*TransientVector).persistent())
+		return true
+	})
+
+	return t.persistent(), nil
+}
+
+// frequencies implements (frequencies coll) which returns a map from the
+// distinct values of the collection to the number of times they appear.
+func frequencies(coll sabre.Value) (*HashMap, error) {
+	counts := NewHashMap().Transient()
+	err := forEach(coll, func(v sabre.Value) error {
+		n, found := counts.Lookup(v)
+		if !found {
+			n = sabre.Int64(0)
+		}
+		counts.assoc(v, n.(sabre.Int64)+1)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return counts.persistent(), nil
+}
+
+// zipmap implements (zipmap keys vals) which returns a map with the keys
+// associated to the corresponding vals.
+func zipmap(keys, vals sabre.Value) (*HashMap, error) {
+	t := NewHashMap().Transient()
+
+	ks, err := toSeq(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	vs, err := toSeq(vals)
+	if err != nil {
+		return nil, err
+	}
+
+	for ks != nil && vs != nil {
+		t.assoc(ks.First(), vs.First())
+
+		if ks, err = nextSeq(ks); err != nil {
+			return nil, err
+		}
+
+		if vs, err = nextSeq(vs); err != nil {
+			return nil, err
+		}
+	}
+
+	return t.persistent(), nil
+}
+
+// partition implements (partition n coll), (partition n step coll) and
+// (partition n step pad coll). It returns a lazy sequence of lists of n
+// values each starting step values apart. The last partition is dropped
+// if it has less than n values, unless a pad collection is given to fill
+// it up.
+func partition(args ...sabre.Value) (sabre.Seq, error) {
+	if len(args) < 2 || len(args) > 4 {
+		return nil, fmt.Errorf("wrong number of args (%d) to 'partition'", len(args))
+	}
+
+	n, err := positiveInt("partition size", args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	step := n
+	if len(args) > 2 {
+		if step, err = positiveInt("partition step", args[1]); err != nil {
+			return nil, err
+		}
+	}
+
+	var pad sabre.Value
+	if len(args) > 3 {
+		pad = args[2]
+	}
+
+	return partitionFrom(n, step, pad, args[len(args)-1]), nil
+}
+
+func partitionFrom(n, step int, pad, coll sabre.Value) sabre.Seq {
+	return NewLazySeq(func() (sabre.Value, error) {
+		s, err := toSeq(coll)
+		if err != nil || s == nil {
+			return nil, err
+		}
+
+		part, err := takeValues(n, s)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(part) < n {
+			if pad == nil {
+				return sabre.Nil{}, nil
+			}
+
+			fill, err := takeValues(n-len(part), pad)
+			if err != nil {
+				return nil, err
+			}
+			return &sabre.List{Values: []sabre.Value{
+				&sabre.List{Values: append(part, fill...)},
+			}}, nil
+		}
+
+		rest, err := dropValues(step, s)
+		if err != nil {
+			return nil, err
+		}
+
+		return &Cons{
+			first: &sabre.List{Values: part},
+			rest:  partitionFrom(n, step, pad, rest),
+		}, nil
+	})
+}
+
+// partitionBy implements (partition-by f coll) which returns a lazy
+// sequence of lists splitting the collection each time f returns a new
+// value.
+func partitionBy(scope sabre.Scope, f sabre.Invokable, coll sabre.Value) sabre.Seq {
+	return NewLazySeq(func() (sabre.Value, error) {
+		s, err := toSeq(coll)
+		if err != nil || s == nil {
+			return nil, err
+		}
+
+		key, err := invoke(scope, f, s.First())
+		if err != nil {
+			return nil, err
+		}
+
+		part := []sabre.Value{s.First()}
+		for {
+			if s, err = nextSeq(s); err != nil {
+				return nil, err
+			} else if s == nil {
+				break
+			}
+
+			k, err := invoke(scope, f, s.First())
+			if err != nil {
+				return nil, err
+			}
+
+			if !equal(key, k) {
+				break
+			}
+			part = append(part, s.First())
+		}
+
+		var rest sabre.Value = sabre.Nil{}
+		if s != nil {
+			rest = s
+		}
+
+		return &Cons{
+			first: &sabre.List{Values: part},
+			rest:  partitionBy(scope, f, rest),
+		}, nil
+	})
+}
+
+// distinct implements (distinct coll) which returns a lazy sequence of the
+// values of the collection with duplicates removed.
+func distinct(coll sabre.Value) sabre.Seq {
+	return distinctFrom(coll, NewHashMap())
+}
+
+func distinctFrom(coll sabre.Value, seen *HashMap) sabre.Seq {
+	return NewLazySeq(func() (sabre.Value, error) {
+		s, err := toSeq(coll)
+		for ; err == nil && s != nil; s, err = nextSeq(s) {
+			v := s.First()
+			if seen.Contains(v) {
+				continue
+			}
+
+			return &Cons{
+				first: v,
+				rest:  distinctFrom(restOf(s), seen.Assoc(v, v)),
+			}, nil
+		}
+
+		return nil, err
+	})
+}
+
+// dedupe implements (dedupe coll) which returns a lazy sequence of the
+// values of the collection with consecutive duplicates removed.
+func dedupe(coll sabre.Value) sabre.Seq {
+	return NewLazySeq(func() (sabre.Value, error) {
+		s, err := toSeq(coll)
+		if err != nil || s == nil {
+			return nil, err
+		}
+
+		return &Cons{first: s.First(), rest: dedupeFrom(restOf(s), s.First())}, nil
+	})
+}
+
+func dedupeFrom(coll, prev sabre.Value) sabre.Seq {
+	return NewLazySeq(func() (sabre.Value, error) {
+		s, err := toSeq(coll)
+		for ; err == nil && s != nil; s, err = nextSeq(s) {
+			if v := s.First(); !equal(v, prev) {
+				return &Cons{first: v, rest: dedupeFrom(restOf(s), v)}, nil
+			}
+		}
+
+		return nil, err
+	})
+}
+
+// interleave implements (interleave & colls) which returns a lazy sequence
+// of the first value of each collection, then the second and so on, until
+// one of the collections is exhausted.
+func interleave(colls ...sabre.Value) sabre.Seq {
+	return NewLazySeq(func() (sabre.Value, error) {
+		if len(colls) == 0 {
+			return sabre.Nil{}, nil
+		}
+
+		firsts := make([]sabre.Value, len(colls))
+		rests := make([]sabre.Value, len(colls))
+		for i, coll := range colls {
+			s, err := toSeq(coll)
+			if err != nil || s == nil {
+				return nil, err
+			}
+
+			firsts[i] = s.First()
+			rests[i] = restOf(s)
+		}
+
+		var res sabre.Seq = interleave(rests...)
+		for i := len(firsts) - 1; i >= 0; i-- {
+			res = &Cons{first: firsts[i], rest: res}
+		}
+		return res, nil
+	})
+}
+
+// flatten implements (flatten coll) which returns a lazy sequence of the
+// values nested in sequential collections such as lists and vectors. Maps,
+// sets and strings are not flattened.
+func flatten(coll sabre.Value) sabre.Seq {
+	if !isSequential(coll) {
+		return NewLazySeq(func() (sabre.Value, error) { return sabre.Nil{}, nil })
+	}
+
+	return flattenFrom(&seqStack{coll: coll})
+}
+
+// seqStack holds the partially consumed collections while flattening, the
+// innermost collection first.
+type seqStack struct {
+	coll sabre.Value
+	next *seqStack
+}
+
+func flattenFrom(stack *seqStack) sabre.Seq {
+	return NewLazySeq(func() (sabre.Value, error) {
+		for stack != nil {
+			s, err := toSeq(stack.coll)
+			if err != nil {
+				return nil, err
+			}
+
+			if s == nil {
+				stack = stack.next
+				continue
+			}
+
+			stack = &seqStack{coll: restOf(s), next: stack.next}
+			if v := s.First(); isSequential(v) {
+				stack = &seqStack{coll: v, next: stack}
+			} else {
+				return &Cons{first: v, rest: flattenFrom(stack)}, nil
+			}
+		}
+
+		return sabre.Nil{}, nil
+	})
+}
+
+// isSequential returns true if the value is an ordered sequence of values
+// such as a list, a vector or a lazy sequence.
+func isSequential(v sabre.Value) bool {
+	switch v.(type) {
+	case *HashMap, *Set, sabre.String:
+		return false
+	}
+
+	_, isSeq := v.(sabre.Seq)
+	return isSeq
+}
+
+// restOf returns the values after the first value of the sequence without
+// realizing them.
+func restOf(s sabre.Seq) sabre.Value {
+	rest, _ := seqRest(s)
+	return rest
+}
+
+// seqValues returns the values of the collection as a new slice.
+func seqValues(coll sabre.Value) ([]sabre.Value, error) {
+	var vals []sabre.Value
+	err := forEach(coll, func(v sabre.Value) error {
+		vals = append(vals, v)
+		return nil
+	})
+	return vals, err
+}
+
+// takeValues returns up to n values from the start of the collection.
+func takeValues(n int, coll sabre.Value) ([]sabre.Value, error) {
+	vals := make([]sabre.Value, 0, n)
+
+	s, err := toSeq(coll)
+	for ; err == nil && s != nil && len(vals) < n; s, err = nextSeq(s) {
+		vals = append(vals, s.First())
+	}
+
+	return vals, err
+}
+
+// dropValues returns the collection without its first n values.
+func dropValues(n int, s sabre.Seq) (sabre.Value, error) {
+	var err error
+	for ; n > 0 && s != nil; n-- {
+		if s, err = nextSeq(s); err != nil {
+			return nil, err
+		}
+	}
+
+	if s == nil {
+		return sabre.Nil{}, nil
+	}
+	return s, nil
+}
+
+func positiveInt(name string, v sabre.Value) (int, error) {
+	n, ok := v.(sabre.Int64)
+	if !ok || n <= 0 {
+		return 0, fmt.Errorf("%s must be a positive integer, got %s", name, v)
+	}
+
+	return int(n), nil
+}
@@ -0,0 +1,203 @@
+package xlisp
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/spy16/sabre"
+)
+
+// Symbols of the dynamic vars bound to the standard streams. Rebinding them,
+// e.g. through with-out-str, redirects the input and output of the
+// evaluated forms.
+const (
+	symIn  = "*in*"
+	symOut = "*out*"
+	symErr = "*err*"
+)
+
+// OutStream is an output stream such as the values of *out* and *err*.
+// Writes are serialized so that the stream can be shared by futures.
+type OutStream struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func newOutStream(w io.Writer) *OutStream {
+	return &OutStream{w: w}
+}
+
+// Write writes p to the underlying writer.
+func (out *OutStream) Write(p []byte) (int, error) {
+	out.mu.Lock()
+	defer out.mu.Unlock()
+
+	return out.w.Write(p)
+}
+
+func (out *OutStream) Eval(_ sabre.Scope) (sabre.Value, error) {
+	return out, nil
+}
+
+func (out *OutStream) String() string {
+	return fmt.Sprintf("#out-stream[%T]", out.w)
+}
+
+// InStream is an input stream such as the value of *in*. Input is buffered
+// by the stream itself so that nothing read ahead is lost between reads.
+type InStream struct {
+	mu sync.Mutex
+	rd *bufio.Reader
+	r  io.Reader
+}
+
+func newInStream(r io.Reader) *InStream {
+	return &InStream{rd: bufio.NewReader(r), r: r}
+}
+
+// ReadLine returns the next line from the stream without the trailing
+// newline. The last line of the input need not end with a newline.
+func (in *InStream) ReadLine() (string, error) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	text, err := in.rd.ReadString('\n')
+	if err != nil && (err != io.EOF || text == "") {
+		return "", err
+	}
+
+	return strings.TrimSuffix(text, "\n"), nil
+}
+
+func (in *InStream) Eval(_ sabre.Scope) (sabre.Value, error) {
+	return in, nil
+}
+
+func (in *InStream) String() string {
+	return fmt.Sprintf("#in-stream[%T]", in.r)
+}
+
+// bindStreams binds the standard streams of the interpreter.
+func (slang *Xlisp) bindStreams() error {
+	streams := map[string]sabre.Value{
+		symIn:  newInStream(slang.stdin),
+		symOut: newOutStream(slang.stdout),
+		symErr: newOutStream(slang.stderr),
+	}
+
+	for sym, stream := range streams {
+		if err := slang.Bind("core/"+sym, stream); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// stdin returns the stream bound to *in* in the given scope.
+func stdin(scope sabre.Scope) (*InStream, error) {
+	v, err := scope.Resolve(symIn)
+	if err != nil {
+		return defaultIn, nil
+	}
+
+	in, ok := v.(*InStream)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an input stream: %s", symIn, v)
+	}
+
+	return in, nil
+}
+
+// stdout returns the writer bound to *out* in the given scope. Besides
+// output streams, any Go value implementing io.Writer such as a tview
+// TextView can be bound to *out*.
+func stdout(scope sabre.Scope) (io.Writer, error) {
+	return outStream(scope, symOut, os.Stdout)
+}
+
+// stderr returns the writer bound to *err* in the given scope, see stdout.
+func stderr(scope sabre.Scope) (io.Writer, error) {
+	return outStream(scope, symErr, os.Stderr)
+}
+
+func outStream(scope sabre.Scope, sym string, def io.Writer) (io.Writer, error) {
+	v, err := scope.Resolve(sym)
+	if err != nil {
+		return def, nil
+	}
+
+	switch w := v.(type) {
+	case io.Writer:
+		return w, nil
+
+	case sabre.Any:
+		if w, ok := w.V.Interface().(io.Writer); ok {
+			return w, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%s is not an output stream: %s", sym, v)
+}
+
+var defaultIn = newInStream(os.Stdin)
+
+// withOutStr evaluates the body with *out* bound to a new buffer and
+// returns the content written to it.
+func withOutStr(scope sabre.Scope, body []sabre.Value) (sabre.Value, error) {
+	var buf bytes.Buffer
+	out := newOutStream(&buf)
+
+	outScope, err := bindDynamicSym(scope, "core/"+symOut, out)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := sabre.Module(body).Eval(outScope); err != nil {
+		return nil, err
+	}
+
+	out.mu.Lock()
+	defer out.mu.Unlock()
+
+	return sabre.String(buf.String()), nil
+}
+
+// withInStr evaluates the body with *in* bound to a stream reading from
+// the given string.
+func withInStr(scope sabre.Scope, args []sabre.Value) (sabre.Value, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("with-in-str requires a string")
+	}
+
+	v, err := sabre.Eval(scope, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	s, ok := v.(sabre.String)
+	if !ok {
+		return nil, fmt.Errorf("with-in-str requires a string, got %s", v)
+	}
+
+	in := newInStream(strings.NewReader(string(s)))
+	inScope, err := bindDynamicSym(scope, "core/"+symIn, in)
+	if err != nil {
+		return nil, err
+	}
+
+	return sabre.Module(args[1:]).Eval(inScope)
+}
+
+// deniedReader fails every read. It replaces the standard input of
+// interpreters without CapProcess.
+type deniedReader struct{}
+
+func (deniedReader) Read(_ []byte) (int, error) {
+	return 0, &CapabilityError{Capability: CapProcess, Name: symIn}
+}
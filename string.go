@@ -0,0 +1,243 @@
+package xlisp
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/spy16/sabre"
+)
+
+// Indices and lengths used by the string functions count runes, not bytes,
+// so that they work as expected with non-ASCII text.
+
+// splitString implements (string/split s sep).
+func splitString(str, sep sabre.String) *sabre.List {
+	return stringList(strings.Split(string(str), string(sep)))
+}
+
+// splitLines implements (string/split-lines s) which splits the string on
+// \n or \r\n.
+func splitLines(s string) *sabre.List {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+	return stringList(lines)
+}
+
+// joinStrings implements (string/join coll) and (string/join sep coll).
+// The values of the collection are converted to strings like str does.
+func joinStrings(args ...sabre.Value) (string, error) {
+	if err := verifyArgCount([]int{1, 2}, args); err != nil {
+		return "", err
+	}
+
+	sep := ""
+	if len(args) == 2 {
+		s, isString := args[0].(sabre.String)
+		if !isString {
+			return "", fmt.Errorf("separator must be a string, not %s", args[0])
+		}
+		sep = string(s)
+	}
+
+	var parts []string
+	err := forEach(args[len(args)-1], func(v sabre.Value) error {
+		parts = append(parts, string(MakeString(v).(sabre.String)))
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Join(parts, sep), nil
+}
+
+// replaceString implements (string/replace s match replacement) which
+// replaces all the occurrences of match. The match is either a string
+// replaced by a string or a regex, see replaceRegex.
+func replaceString(scope sabre.Scope, s string, match, replacement sabre.Value) (string, error) {
+	switch m := match.(type) {
+	case sabre.String:
+		r, isString := replacement.(sabre.String)
+		if !isString {
+			return "", fmt.Errorf("replacement of a string must be a string, not %s",
+				reflect.TypeOf(replacement))
+		}
+		return strings.ReplaceAll(s, string(m), string(r)), nil
+
+	case *Regex:
+		return replaceRegex(scope, s, m, replacement)
+	}
+
+	return "", fmt.Errorf("match must be a string or a regex, not %s",
+		reflect.TypeOf(match))
+}
+
+// indexOf implements (string/index-of s sub) and (string/index-of s sub
+// from) which return the index of the first occurrence of sub at or after
+// from, or nil if there is none.
+func indexOf(s, sub string, from ...int) (sabre.Value, error) {
+	if len(from) > 1 {
+		return nil, fmt.Errorf("wrong number of args (%d) to 'index-of'", len(from)+2)
+	}
+
+	start := 0
+	if len(from) == 1 {
+		start = from[0]
+		if start < 0 || start > utf8.RuneCountInString(s) {
+			return sabre.Nil{}, nil
+		}
+	}
+
+	offset := byteOffset(s, start)
+	i := strings.Index(s[offset:], sub)
+	if i < 0 {
+		return sabre.Nil{}, nil
+	}
+
+	return sabre.Int64(start + utf8.RuneCountInString(s[offset:offset+i])), nil
+}
+
+// lastIndexOf implements (string/last-index-of s sub) which returns the
+// index of the last occurrence of sub or nil if there is none.
+func lastIndexOf(s, sub string) sabre.Value {
+	i := strings.LastIndex(s, sub)
+	if i < 0 {
+		return sabre.Nil{}
+	}
+
+	return sabre.Int64(utf8.RuneCountInString(s[:i]))
+}
+
+// subs implements (string/subs s start) and (string/subs s start end)
+// which return the runes of the string from start up to, but excluding,
+// end.
+func subs(s string, start int, end ...int) (string, error) {
+	runes := []rune(s)
+
+	stop := len(runes)
+	if len(end) > 1 {
+		return "", fmt.Errorf("wrong number of args (%d) to 'subs'", len(end)+2)
+	} else if len(end) == 1 {
+		stop = end[0]
+	}
+
+	if start < 0 || stop > len(runes) || start > stop {
+		return "", fmt.Errorf("string index out of range: [%d, %d) for string of length %d",
+			start, stop, len(runes))
+	}
+
+	return string(runes[start:stop]), nil
+}
+
+// isBlank implements (string/blank? s) which returns true if the value is
+// nil or a string made only of whitespace.
+func isBlank(v sabre.Value) (bool, error) {
+	switch s := v.(type) {
+	case nil, sabre.Nil:
+		return true, nil
+
+	case sabre.String:
+		return strings.TrimSpace(string(s)) == "", nil
+	}
+
+	return false, fmt.Errorf("argument must be a string, not %s", v)
+}
+
+// padRight implements (string/pad s width) and (string/pad s width
+// padding) which append the padding, a space by default, to the string
+// until it is width runes long.
+func padRight(s string, width int, padding ...string) (string, error) {
+	fill, err := padFill(s, width, padding)
+	return s + fill, err
+}
+
+// padLeft is like padRight but prepends the padding.
+func padLeft(s string, width int, padding ...string) (string, error) {
+	fill, err := padFill(s, width, padding)
+	return fill + s, err
+}
+
+func padFill(s string, width int, padding []string) (string, error) {
+	pad := " "
+	if len(padding) > 1 {
+		return "", fmt.Errorf("wrong number of args (%d) to 'pad'", len(padding)+2)
+	} else if len(padding) == 1 {
+		pad = padding[0]
+	}
+
+	if pad == "" {
+		return "", fmt.Errorf("padding must not be empty")
+	}
+
+	n := width - utf8.RuneCountInString(s)
+	if n <= 0 {
+		return "", nil
+	}
+
+	fill := []rune(strings.Repeat(pad, n))
+	return string(fill[:n]), nil
+}
+
+// repeatString implements (string/repeat s n).
+func repeatString(s string, n int) (string, error) {
+	if n < 0 {
+		return "", fmt.Errorf("repeat count must not be negative, got %d", n)
+	}
+
+	return strings.Repeat(s, n), nil
+}
+
+// reverseString implements (string/reverse s) which reverses the runes of
+// the string.
+func reverseString(s string) string {
+	runes := []rune(s)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes)
+}
+
+// capitalize implements (string/capitalize s) which upper-cases the first
+// rune of the string and lower-cases the rest.
+func capitalize(s string) string {
+	r, size := utf8.DecodeRuneInString(s)
+	if size == 0 {
+		return s
+	}
+
+	return string(unicode.ToUpper(r)) + strings.ToLower(s[size:])
+}
+
+// trimLeft implements (string/triml s).
+func trimLeft(s string) string {
+	return strings.TrimLeftFunc(s, unicode.IsSpace)
+}
+
+// trimRight implements (string/trimr s).
+func trimRight(s string) string {
+	return strings.TrimRightFunc(s, unicode.IsSpace)
+}
+
+// byteOffset returns the byte offset of the i-th rune of the string.
+func byteOffset(s string, i int) int {
+	for offset := range s {
+		if i == 0 {
+			return offset
+		}
+		i--
+	}
+	return len(s)
+}
+
+func stringList(strs []string) *sabre.List {
+	values := make([]sabre.Value, 0, len(strs))
+	for _, s := range strs {
+		values = append(values, sabre.String(s))
+	}
+	return &sabre.List{Values: values}
+}
@@ -0,0 +1,454 @@
+package xlisp
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/spy16/sabre"
+)
+
+var errTransientUsed = errors.New("transient used after persistent! call")
+
+// editToken identifies the trie nodes owned by a transient collection.
+// Owned nodes are modified in place instead of being copied. The token is
+// deactivated when the transient is made persistent, from then on the
+// nodes are shared read-only by the persistent collection.
+type editToken struct {
+	active bool
+}
+
+func newEditToken() *editToken {
+	return &editToken{active: true}
+}
+
+func (edit *editToken) check() error {
+	if !edit.active {
+		return errTransientUsed
+	}
+	return nil
+}
+
+// TransientVector is a mutable version of a Vector for building a vector
+// with many updates without allocating a new vector for each of them. A
+// transient must not be used after Persistent has been called on it.
+type TransientVector struct {
+	edit  *editToken
+	cnt   int
+	shift uint
+	root  *vnode
+	tail  []sabre.Value
+}
+
+// Transient returns a transient vector holding the values of the vector.
+// The vector itself is never modified by the transient.
+func (vec *Vector) Transient() *TransientVector {
+	tail := make([]sabre.Value, len(vec.tail), vecWidth)
+	copy(tail, vec.tail)
+
+	return &TransientVector{
+		edit:  newEditToken(),
+		cnt:   vec.cnt,
+		shift: vec.shift,
+		root:  vec.root,
+		tail:  tail,
+	}
+}
+
+// Eval returns the transient itself.
+func (t *TransientVector) Eval(_ sabre.Scope) (sabre.Value, error) { return t, nil }
+
+// Size returns the number of values in the transient.
+func (t *TransientVector) Size() int { return t.cnt }
+
+// Conj appends the value to the transient.
+func (t *TransientVector) Conj(v sabre.Value) error {
+	if err := t.edit.check(); err != nil {
+		return err
+	}
+
+	t.conj(v)
+	return nil
+}
+
+// Assoc replaces the value at the index. An index equal to the size of the
+// transient appends the value.
+func (t *TransientVector) Assoc(i int, v sabre.Value) error {
+	if err := t.edit.check(); err != nil {
+		return err
+	}
+
+	off := tailOffset(t.cnt)
+	switch {
+	case i == t.cnt:
+		t.conj(v)
+
+	case i < 0 || i > t.cnt:
+		return fmt.Errorf("index %d out of bounds for vector of size %d",
+			i, t.cnt)
+
+	case i >= off:
+		t.tail[i-off] = v
+
+	default:
+		t.root = t.assocNode(t.shift, t.root, i, v)
+	}
+
+	return nil
+}
+
+// Persistent returns a vector holding the values of the transient and ends
+// the use of the transient.
+func (t *TransientVector) Persistent() (*Vector, error) {
+	if err := t.edit.check(); err != nil {
+		return nil, err
+	}
+
+	return t.persistent(), nil
+}
+
+func (t *TransientVector) String() string { return "#<transient vector>" }
+
+func (t *TransientVector) persistent() *Vector {
+	t.edit.active = false
+	return &Vector{cnt: t.cnt, shift: t.shift, root: t.root, tail: t.tail}
+}
+
+func (t *TransientVector) conj(v sabre.Value) {
+	if t.cnt-tailOffset(t.cnt) < vecWidth {
+		t.tail = append(t.tail, v)
+		t.cnt++
+		return
+	}
+
+	tailNode := &vnode{edit: t.edit, values: t.tail}
+	if (t.cnt >> vecBits) > (1 << t.shift) {
+		t.root = &vnode{
+			edit:     t.edit,
+			children: []*vnode{t.root, newPath(t.edit, t.shift, tailNode)},
+		}
+		t.shift += vecBits
+	} else {
+		t.root = t.pushTail(t.shift, t.root, tailNode)
+	}
+
+	t.tail = make([]sabre.Value, 1, vecWidth)
+	t.tail[0] = v
+	t.cnt++
+}
+
+func (t *TransientVector) pushTail(level uint, parent, tail *vnode) *vnode {
+	parent = t.editable(parent)
+	sub := ((t.cnt - 1) >> level) & vecMask
+
+	var child *vnode
+	switch {
+	case level == vecBits:
+		child = tail
+	case sub < len(parent.children):
+		child = t.pushTail(level-vecBits, parent.children[sub], tail)
+	default:
+		child = newPath(t.edit, level-vecBits, tail)
+	}
+
+	if sub < len(parent.children) {
+		parent.children[sub] = child
+	} else {
+		parent.children = append(parent.children, child)
+	}
+
+	return parent
+}
+
+func (t *TransientVector) assocNode(level uint, node *vnode, i int, v sabre.Value) *vnode {
+	node = t.editable(node)
+	if level == 0 {
+		node.values[i&vecMask] = v
+		return node
+	}
+
+	sub := (i >> level) & vecMask
+	node.children[sub] = t.assocNode(level-vecBits, node.children[sub], i, v)
+	return node
+}
+
+// editable returns the node if it is owned by the transient or a copy of
+// it owned by the transient otherwise.
+func (t *TransientVector) editable(node *vnode) *vnode {
+	if node.edit == t.edit {
+		return node
+	}
+
+	return &vnode{
+		edit:     t.edit,
+		children: append([]*vnode(nil), node.children...),
+		values:   append([]sabre.Value(nil), node.values...),
+	}
+}
+
+// TransientHashMap is a mutable version of a HashMap for building a map
+// with many updates. A transient must not be used after Persistent has
+// been called on it.
+type TransientHashMap struct {
+	edit *editToken
+	root *hamtNode
+	cnt  int
+}
+
+// Transient returns a transient map holding the entries of the map. The
+// map itself is never modified by the transient.
+func (hm *HashMap) Transient() *TransientHashMap {
+	return &TransientHashMap{edit: newEditToken(), root: hm.root, cnt: hm.cnt}
+}
+
+// Eval returns the transient itself.
+func (t *TransientHashMap) Eval(_ sabre.Scope) (sabre.Value, error) { return t, nil }
+
+// Size returns the number of entries in the transient.
+func (t *TransientHashMap) Size() int { return t.cnt }
+
+// Lookup returns the value associated with the key and whether the key was
+// found.
+func (t *TransientHashMap) Lookup(key sabre.Value) (sabre.Value, bool) {
+	if t.root == nil {
+		return nil, false
+	}
+
+	return t.root.get(0, hashValue(key), key)
+}
+
+// Assoc associates the key with the value.
+func (t *TransientHashMap) Assoc(key, val sabre.Value) error {
+	if err := t.edit.check(); err != nil {
+		return err
+	}
+
+	t.assoc(key, val)
+	return nil
+}
+
+// Dissoc removes the entry for the key.
+func (t *TransientHashMap) Dissoc(key sabre.Value) error {
+	if err := t.edit.check(); err != nil {
+		return err
+	}
+
+	if t.root == nil {
+		return nil
+	}
+
+	root, removed := t.root.dissoc(t.edit, 0, hashValue(key), key)
+	if removed {
+		t.root = root
+		t.cnt--
+	}
+	return nil
+}
+
+// Persistent returns a map holding the entries of the transient and ends
+// the use of the transient.
+func (t *TransientHashMap) Persistent() (*HashMap, error) {
+	if err := t.edit.check(); err != nil {
+		return nil, err
+	}
+
+	return t.persistent(), nil
+}
+
+func (t *TransientHashMap) String() string { return "#<transient hash-map>" }
+
+func (t *TransientHashMap) persistent() *HashMap {
+	t.edit.active = false
+	return &HashMap{root: t.root, cnt: t.cnt}
+}
+
+func (t *TransientHashMap) assoc(key, val sabre.Value) {
+	root := t.root
+	if root == nil {
+		root = &hamtNode{edit: t.edit}
+	}
+
+	root, added := root.assoc(t.edit, 0, hashValue(key), key, val)
+	t.root = root
+	if added {
+		t.cnt++
+	}
+}
+
+// TransientSet is a mutable version of a Set for building a set with many
+// updates. A transient must not be used after Persistent has been called
+// on it.
+type TransientSet struct {
+	m *TransientHashMap
+}
+
+// Transient returns a transient set holding the members of the set. The
+// set itself is never modified by the transient.
+func (set *Set) Transient() *TransientSet {
+	return &TransientSet{m: set.m.Transient()}
+}
+
+// Eval returns the transient itself.
+func (t *TransientSet) Eval(_ sabre.Scope) (sabre.Value, error) { return t, nil }
+
+// Size returns the number of members of the transient.
+func (t *TransientSet) Size() int { return t.m.cnt }
+
+// Conj adds the value to the transient.
+func (t *TransientSet) Conj(v sabre.Value) error { return t.m.Assoc(v, v) }
+
+// Disj removes the value from the transient.
+func (t *TransientSet) Disj(v sabre.Value) error { return t.m.Dissoc(v) }
+
+// Persistent returns a set holding the members of the transient and ends
+// the use of the transient.
+func (t *TransientSet) Persistent() (*Set, error) {
+	m, err := t.m.Persistent()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Set{m: m}, nil
+}
+
+func (t *TransientSet) String() string { return "#<transient hash-set>" }
+
+// transient implements (transient coll).
+func transient(coll sabre.Value) (sabre.Value, error) {
+	switch c := coll.(type) {
+	case *Vector:
+		return c.Transient(), nil
+	case *HashMap:
+		return c.Transient(), nil
+	case *Set:
+		return c.Transient(), nil
+	}
+
+	return nil, fmt.Errorf("cannot create transient of type %s",
+		reflect.TypeOf(coll))
+}
+
+// persistent implements (persistent! coll).
+func persistent(coll sabre.Value) (sabre.Value, error) {
+	switch c := coll.(type) {
+	case *TransientVector:
+		return c.Persistent()
+	case *TransientHashMap:
+		return c.Persistent()
+	case *TransientSet:
+		return c.Persistent()
+	}
+
+	return nil, notTransient(coll)
+}
+
+// conjTransient implements (conj! coll & vals).
+func conjTransient(coll sabre.Value, vals ...sabre.Value) (sabre.Value, error) {
+	for _, v := range vals {
+		var err error
+		switch c := coll.(type) {
+		case *TransientVector:
+			err = c.Conj(v)
+
+		case *TransientSet:
+			err = c.Conj(v)
+
+		case *TransientHashMap:
+			switch entry := v.(type) {
+			case *Vector:
+				if entry.Size() != 2 {
+					return nil, fmt.Errorf("cannot conj %s onto a hash-map, "+
+						"expecting a [key value] vector or a hash-map", v)
+				}
+				err = c.Assoc(entry.Nth(0), entry.Nth(1))
+
+			case *HashMap:
+				entry.each(func(key, val sabre.Value) bool {
+					err = c.Assoc(key, val)
+					return err == nil
+				})
+
+			default:
+				return nil, fmt.Errorf("cannot conj %s onto a hash-map, "+
+					"expecting a [key value] vector or a hash-map", v)
+			}
+
+		default:
+			return nil, notTransient(coll)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return coll, nil
+}
+
+// assocTransient implements (assoc! coll & kvs).
+func assocTransient(coll sabre.Value, kvs ...sabre.Value) (sabre.Value, error) {
+	if len(kvs)%2 != 0 {
+		return nil, fmt.Errorf("assoc! expects even number of arguments after "+
+			"map/vector, got %d", len(kvs))
+	}
+
+	for i := 0; i < len(kvs); i += 2 {
+		var err error
+		switch c := coll.(type) {
+		case *TransientHashMap:
+			err = c.Assoc(kvs[i], kvs[i+1])
+
+		case *TransientVector:
+			index, isInt := kvs[i].(sabre.Int64)
+			if !isInt {
+				return nil, fmt.Errorf("key must be integer")
+			}
+			err = c.Assoc(int(index), kvs[i+1])
+
+		default:
+			return nil, notTransient(coll)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return coll, nil
+}
+
+// dissocTransient implements (dissoc! coll & keys).
+func dissocTransient(coll sabre.Value, keys ...sabre.Value) (sabre.Value, error) {
+	t, ok := coll.(*TransientHashMap)
+	if !ok {
+		return nil, fmt.Errorf("cannot dissoc! on type %s", reflect.TypeOf(coll))
+	}
+
+	for _, k := range keys {
+		if err := t.Dissoc(k); err != nil {
+			return nil, err
+		}
+	}
+
+	return t, nil
+}
+
+// disjTransient implements (disj! coll & vals).
+func disjTransient(coll sabre.Value, vals ...sabre.Value) (sabre.Value, error) {
+	t, ok := coll.(*TransientSet)
+	if !ok {
+		return nil, fmt.Errorf("cannot disj! on type %s", reflect.TypeOf(coll))
+	}
+
+	for _, v := range vals {
+		if err := t.Disj(v); err != nil {
+			return nil, err
+		}
+	}
+
+	return t, nil
+}
+
+func notTransient(v sabre.Value) error {
+	return fmt.Errorf("argument must be a transient, not %s", reflect.TypeOf(v))
+}
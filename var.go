@@ -0,0 +1,58 @@
+package xlisp
+
+import (
+	"fmt"
+
+	"github.com/spy16/sabre"
+)
+
+// VarQuote returns the var a symbol refers to instead of its value, e.g.
+// (var inc) or #'inc.
+var VarQuote = sabre.SpecialForm{
+	Name:  "var",
+	Parse: parseVarQuote,
+}
+
+func parseVarQuote(_ sabre.Scope, args []sabre.Value) (*sabre.Fn, error) {
+	if err := verifyArgCount([]int{1}, args); err != nil {
+		return nil, err
+	}
+
+	sym, isSymbol := args[0].(sabre.Symbol)
+	if !isSymbol {
+		return nil, fmt.Errorf("var requires a symbol, not %s", args[0])
+	}
+
+	return &sabre.Fn{
+		Func: func(scope sabre.Scope, _ []sabre.Value) (sabre.Value, error) {
+			xl := interpreter(scope)
+			if xl == nil {
+				return nil, fmt.Errorf("cannot resolve var %s: scope has no vars",
+					sym.Value)
+			}
+
+			vr, _, err := xl.resolveVar(scopeNS(scope), sym.Value)
+			if err != nil {
+				return nil, err
+			}
+			return vr, nil
+		},
+	}, nil
+}
+
+// Eval returns the var itself.
+func (vr *Var) Eval(_ sabre.Scope) (sabre.Value, error) { return vr, nil }
+
+// Deref returns the root value of the var.
+func (vr *Var) Deref() (sabre.Value, error) { return vr.Value, nil }
+
+// Invoke invokes the value of the var with the arguments so that vars of
+// functions can be called like the functions, e.g. (#'inc 1).
+func (vr *Var) Invoke(scope sabre.Scope, args ...sabre.Value) (sabre.Value, error) {
+	f, ok := vr.Value.(sabre.Invokable)
+	if !ok {
+		return nil, fmt.Errorf("cannot invoke value of var %s", vr)
+	}
+
+	return f.Invoke(scope, args...)
+}
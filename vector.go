@@ -0,0 +1,418 @@
+package xlisp
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spy16/sabre"
+)
+
+const (
+	vecBits  = 5
+	vecWidth = 1 << vecBits
+	vecMask  = vecWidth - 1
+)
+
+// Vector is a persistent vector. Values are stored in the leaves of a trie
+// with 32 children per node, the last 32 values are kept in a separate
+// tail. Conj, Assoc and Nth run in effectively constant time and share all
+// but one path of the trie with the original vector.
+type Vector struct {
+	sabre.Position
+
+	meta  *HashMap
+	cnt   int
+	shift uint
+	root  *vnode
+	tail  []sabre.Value
+}
+
+// vnode is a node of the vector trie. Nodes owned by a transient vector
+// carry its edit token and are modified in place by the transient.
+type vnode struct {
+	edit     *editToken
+	children []*vnode
+	values   []sabre.Value
+}
+
+var emptyVNode = &vnode{}
+
+// NewVector returns a vector holding the given values.
+func NewVector(vals ...sabre.Value) *Vector {
+	vec := &Vector{shift: vecBits, root: emptyVNode}
+	if len(vals) == 0 {
+		return vec
+	}
+
+	t := vec.Transient()
+	for _, v := range vals {
+		t.conj(v)
+	}
+	return t.persistent()
+}
+
+// Eval evaluates each value in the vector and returns the results as a new
+// vector.
+func (vec *Vector) Eval(scope sabre.Scope) (sabre.Value, error) {
+	t := NewVector().Transient()
+	for i := 0; i < vec.cnt; i++ {
+		v, err := sabre.Eval(scope, vec.Nth(i))
+		if err != nil {
+			return nil, err
+		}
+		t.conj(v)
+	}
+
+	res := t.persistent()
+	res.Position = vec.Position
+	res.meta = vec.meta
+	return res, nil
+}
+
+// Invoke of a vector performs an index lookup.
+func (vec *Vector) Invoke(scope sabre.Scope, args ...sabre.Value) (sabre.Value, error) {
+	vals, err := evalValueList(scope, args)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(vals) != 1 {
+		return nil, fmt.Errorf("call requires exactly 1 argument, got %d", len(vals))
+	}
+
+	index, isInt := vals[0].(sabre.Int64)
+	if !isInt {
+		return nil, fmt.Errorf("key must be integer")
+	}
+
+	if index < 0 || int(index) >= vec.cnt {
+		return nil, fmt.Errorf("index out of bounds")
+	}
+
+	return vec.Nth(int(index)), nil
+}
+
+// Size returns the number of values in the vector.
+func (vec *Vector) Size() int { return vec.cnt }
+
+// Nth returns the value at the index. The index must be within bounds.
+func (vec *Vector) Nth(i int) sabre.Value {
+	if i >= vec.tailOffset() {
+		return vec.tail[i-vec.tailOffset()]
+	}
+
+	node := vec.root
+	for level := vec.shift; level > 0; level -= vecBits {
+		node = node.children[(i>>level)&vecMask]
+	}
+
+	return node.values[i&vecMask]
+}
+
+// Assoc returns a new vector with the value at the index replaced. An index
+// equal to the size of the vector appends the value.
+func (vec *Vector) Assoc(i int, v sabre.Value) (*Vector, error) {
+	switch {
+	case i == vec.cnt:
+		return vec.conj(v), nil
+
+	case i < 0 || i > vec.cnt:
+		return nil, fmt.Errorf("index %d out of bounds for vector of size %d",
+			i, vec.cnt)
+
+	case i >= vec.tailOffset():
+		tail := append([]sabre.Value(nil), vec.tail...)
+		tail[i-vec.tailOffset()] = v
+
+		res := *vec
+		res.tail = tail
+		return &res, nil
+	}
+
+	res := *vec
+	res.root = assocNode(vec.shift, vec.root, i, v)
+	return &res, nil
+}
+
+func assocNode(level uint, node *vnode, i int, v sabre.Value) *vnode {
+	if level == 0 {
+		values := append([]sabre.Value(nil), node.values...)
+		values[i&vecMask] = v
+		return &vnode{values: values}
+	}
+
+	children := append([]*vnode(nil), node.children...)
+	sub := (i >> level) & vecMask
+	children[sub] = assocNode(level-vecBits, node.children[sub], i, v)
+	return &vnode{children: children}
+}
+
+// First returns the first value of the vector or nil if it is empty.
+func (vec *Vector) First() sabre.Value {
+	if vec.cnt == 0 {
+		return nil
+	}
+
+	return vec.Nth(0)
+}
+
+// Next returns a sequence of the values after the first one.
+func (vec *Vector) Next() sabre.Seq {
+	if vec.cnt <= 1 {
+		return nil
+	}
+
+	return &vectorSeq{vec: vec, i: 1}
+}
+
+// Cons returns a sequence with the value prepended to the values of the
+// vector.
+func (vec *Vector) Cons(v sabre.Value) sabre.Seq {
+	return &Cons{first: v, rest: vec}
+}
+
+// Conj returns a new vector with the values appended.
+func (vec *Vector) Conj(vals ...sabre.Value) sabre.Seq {
+	if len(vals) == 1 {
+		return vec.conj(vals[0])
+	}
+
+	t := vec.Transient()
+	for _, v := range vals {
+		t.conj(v)
+	}
+
+	res := t.persistent()
+	res.Position = vec.Position
+	res.meta = vec.meta
+	return res
+}
+
+// Values returns the values of the vector as a slice.
+func (vec *Vector) Values() []sabre.Value {
+	vals := make([]sabre.Value, vec.cnt)
+	for i := range vals {
+		vals[i] = vec.Nth(i)
+	}
+	return vals
+}
+
+// Compare returns true if the other value is a sequence with the same
+// values in the same order.
+func (vec *Vector) Compare(other sabre.Value) bool {
+	return seqEqual(vec, other)
+}
+
+// CompareTo compares the vector with another vector. Shorter vectors are
+// less than longer ones, vectors of the same size are compared value by
+// value.
+func (vec *Vector) CompareTo(other sabre.Value) (int, error) {
+	o, ok := other.(*Vector)
+	if !ok {
+		return 0, notComparable(vec, other)
+	}
+
+	if vec.cnt != o.cnt {
+		return vec.cnt - o.cnt, nil
+	}
+
+	for i := 0; i < vec.cnt; i++ {
+		c, err := compare(vec.Nth(i), o.Nth(i))
+		if err != nil || c != 0 {
+			return c, err
+		}
+	}
+
+	return 0, nil
+}
+
+func (vec *Vector) String() string {
+	return containerString(vec.Values(), "[", "]")
+}
+
+func (vec *Vector) tailOffset() int { return tailOffset(vec.cnt) }
+
+// tailOffset returns the index of the first value kept in the tail of a
+// vector with cnt values.
+func tailOffset(cnt int) int {
+	if cnt < vecWidth {
+		return 0
+	}
+
+	return ((cnt - 1) >> vecBits) << vecBits
+}
+
+func (vec *Vector) conj(v sabre.Value) *Vector {
+	if vec.cnt-vec.tailOffset() < vecWidth {
+		tail := make([]sabre.Value, len(vec.tail)+1)
+		copy(tail, vec.tail)
+		tail[len(vec.tail)] = v
+
+		res := *vec
+		res.cnt++
+		res.tail = tail
+		return &res
+	}
+
+	tailNode := &vnode{values: vec.tail}
+	shift := vec.shift
+
+	var root *vnode
+	if (vec.cnt >> vecBits) > (1 << vec.shift) {
+		root = &vnode{children: []*vnode{vec.root, newPath(nil, vec.shift, tailNode)}}
+		shift += vecBits
+	} else {
+		root = vec.pushTail(vec.shift, vec.root, tailNode)
+	}
+
+	return &Vector{
+		Position: vec.Position,
+		meta:     vec.meta,
+		cnt:      vec.cnt + 1,
+		shift:    shift,
+		root:     root,
+		tail:     []sabre.Value{v},
+	}
+}
+
+func (vec *Vector) pushTail(level uint, parent, tail *vnode) *vnode {
+	sub := ((vec.cnt - 1) >> level) & vecMask
+
+	var child *vnode
+	switch {
+	case level == vecBits:
+		child = tail
+	case sub < len(parent.children):
+		child = vec.pushTail(level-vecBits, parent.children[sub], tail)
+	default:
+		child = newPath(nil, level-vecBits, tail)
+	}
+
+	children := append([]*vnode(nil), parent.children...)
+	if sub < len(children) {
+		children[sub] = child
+	} else {
+		children = append(children, child)
+	}
+
+	return &vnode{children: children}
+}
+
+func newPath(edit *editToken, level uint, node *vnode) *vnode {
+	if level == 0 {
+		return node
+	}
+
+	return &vnode{edit: edit, children: []*vnode{newPath(edit, level-vecBits, node)}}
+}
+
+// vectorSeq is a sequence over the values of a vector starting at an
+// index.
+type vectorSeq struct {
+	vec *Vector
+	i   int
+}
+
+func (vs *vectorSeq) Eval(_ sabre.Scope) (sabre.Value, error) { return vs, nil }
+
+func (vs *vectorSeq) First() sabre.Value { return vs.vec.Nth(vs.i) }
+
+func (vs *vectorSeq) Next() sabre.Seq {
+	if vs.i+1 >= vs.vec.cnt {
+		return nil
+	}
+
+	return &vectorSeq{vec: vs.vec, i: vs.i + 1}
+}
+
+func (vs *vectorSeq) Cons(v sabre.Value) sabre.Seq {
+	return &Cons{first: v, rest: vs}
+}
+
+func (vs *vectorSeq) Conj(vals ...sabre.Value) sabre.Seq {
+	return Realize(vs).Conj(vals...)
+}
+
+func (vs *vectorSeq) Size() int { return vs.vec.cnt - vs.i }
+
+func (vs *vectorSeq) Compare(other sabre.Value) bool {
+	return seqEqual(vs, other)
+}
+
+func (vs *vectorSeq) String() string {
+	return Realize(vs).String()
+}
+
+// seqEqual returns true if the other value is a sequential collection with
+// the same values as the sequence. Maps and sets are never equal to a
+// sequence.
+func seqEqual(s sabre.Seq, other sabre.Value) bool {
+	switch other.(type) {
+	case *HashMap, *Set:
+		return false
+	}
+
+	o, ok := other.(sabre.Seq)
+	if !ok {
+		return false
+	}
+
+	sized, hasSize := other.(interface{ Size() int })
+	if n, ok := s.(interface{ Size() int }); ok && hasSize &&
+		n.Size() >= 0 && sized.Size() >= 0 && n.Size() != sized.Size() {
+		return false
+	}
+
+	eq, err := seqValuesEqual(s, o)
+	return eq && err == nil
+}
+
+// seqValuesEqual compares the values of the sequences in order and returns
+// the error raised while realizing either of them, if any.
+func seqValuesEqual(s, other sabre.Seq) (bool, error) {
+	a, err := toSeq(s)
+	if err != nil {
+		return false, err
+	}
+
+	b, err := toSeq(other)
+	for err == nil && a != nil && b != nil {
+		var eq bool
+		if eq, err = equals(a.First(), b.First()); err != nil || !eq {
+			return false, err
+		}
+
+		if a, err = nextSeq(a); err != nil {
+			break
+		}
+		b, err = nextSeq(b)
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return a == nil && b == nil, nil
+}
+
+// formValues returns the values of a vector form, which may be a Vector
+// read from source or a sabre.Vector constructed from Go.
+func formValues(form sabre.Value) ([]sabre.Value, bool) {
+	switch vec := form.(type) {
+	case *Vector:
+		return vec.Values(), true
+
+	case sabre.Vector:
+		return vec.Values, true
+	}
+
+	return nil, false
+}
+
+func containerString(vals []sabre.Value, begin, end string) string {
+	parts := make([]string, len(vals))
+	for i, v := range vals {
+		parts[i] = fmt.Sprintf("%v", v)
+	}
+	return begin + strings.Join(parts, " ") + end
+}
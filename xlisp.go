@@ -1,8 +1,12 @@
 package xlisp
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
 	"strings"
 	"sync"
 
@@ -12,22 +16,72 @@ import (
 const (
 	nsSeparator = '/'
 	defaultNS   = "user"
+	coreFile    = "core.xlisp"
 )
 
-// returns new xlisp instance
-func New() *Xlisp {
+// New returns a new xlisp instance configured with the given options. New
+// panics if the extra bindings or the core library given through the
+// options cannot be loaded.
+func New(opts ...Option) *Xlisp {
 	sl := &Xlisp{
-		mu:       &sync.RWMutex{},
-		bindings: map[nsSymbol]sabre.Value{},
+		mu:             &sync.RWMutex{},
+		bindings:       map[nsSymbol]*Var{},
+		aliases:        map[string]map[string]string{},
+		refers:         map[nsSymbol]nsSymbol{},
+		loaded:         map[string]bool{},
+		caps:           CapAll,
+		interopAllowed: map[string]bool{},
+		initialNS:      defaultNS,
+		goBindings:     map[string]interface{}{},
+		stdin:          os.Stdin,
+		stdout:         os.Stdout,
+		stderr:         os.Stderr,
+		coreLib:        strings.NewReader(coreLibrary),
+	}
+
+	for _, member := range DefaultInteropAllowList {
+		sl.interopAllowed[member] = true
+	}
+
+	for _, opt := range opts {
+		opt(sl)
 	}
 
 	if err := BindAll(sl); err != nil {
 		panic(err)
 	}
+
+	if err := sl.bindNS(); err != nil {
+		panic(err)
+	}
+
+	if err := sl.bindStreams(); err != nil {
+		panic(err)
+	}
+
+	if err := sl.bindDocs(); err != nil {
+		panic(err)
+	}
+
+	if err := sl.restrict(); err != nil {
+		panic(err)
+	}
+
+	_ = sl.SwitchNS(sabre.Symbol{Value: sl.initialNS})
+	for sym, v := range sl.goBindings {
+		if err := sl.BindGo(sym, v); err != nil {
+			panic(fmt.Errorf("failed to bind %s: %v", sym, err))
+		}
+	}
 	sl.checkNS = true
 
-	_ = sl.SwitchNS(sabre.Symbol{Value: defaultNS})
-	_ = sl.BindGo("ns", sl.SwitchNS)
+	if sl.coreLib != nil {
+		if err := sl.loadCore(); err != nil {
+			panic(fmt.Errorf("failed to load core library: %v", err))
+		}
+		_ = sl.SwitchNS(sabre.Symbol{Value: sl.initialNS})
+	}
+
 	return sl
 }
 
@@ -36,40 +90,397 @@ type Xlisp struct {
 	mu        *sync.RWMutex
 	currentNS string
 	checkNS   bool
-	bindings  map[nsSymbol]sabre.Value
+	bindings  map[nsSymbol]*Var
+	aliases   map[string]map[string]string
+	refers    map[nsSymbol]nsSymbol
+	loaded    map[string]bool
+	loading   []string
+	limits    Limits
+
+	caps           Capability
+	interopAllowed map[string]bool
+
+	initialNS  string
+	goBindings map[string]interface{}
+	coreLib    io.Reader
+	coreSource string
+	stdin      io.Reader
+	stdout     io.Writer
+	stderr     io.Writer
+	randMu     sync.Mutex
+	random     *rand.Rand
 }
 
-// Eval evaluates the given value in Slang context.
+func (slang *Xlisp) loadCore() error {
+	src, err := ioutil.ReadAll(slang.coreLib)
+	if err != nil {
+		return err
+	}
+	slang.coreSource = string(src)
+
+	rd := NewReader(strings.NewReader(slang.coreSource))
+	rd.File = coreFile
+
+	mod, err := rd.All()
+	if err != nil {
+		return err
+	}
+
+	_, err = slang.Eval(mod)
+	return err
+}
+
+// Stdin returns the standard input of the interpreter.
+func (slang *Xlisp) Stdin() io.Reader { return slang.stdin }
+
+// Stdout returns the standard output of the interpreter.
+func (slang *Xlisp) Stdout() io.Writer { return slang.stdout }
+
+// Stderr returns the standard error of the interpreter.
+func (slang *Xlisp) Stderr() io.Writer { return slang.stderr }
+
+// Eval evaluates the given value in Slang context. Evaluation errors are
+// returned as TraceError.
 func (slang *Xlisp) Eval(v sabre.Value) (sabre.Value, error) {
-	return sabre.Eval(slang, v)
+	return slang.EvalContext(context.Background(), v)
+}
+
+// EvalContext evaluates the given value like Eval but stops as soon as the
+// context is cancelled or the evaluation exceeds the limits set through
+// SetLimits. Cancellation is checked at every function call and loop
+// iteration, including the ones in futures started by the evaluation.
+// Forms accessing members of Go values which are not permitted by the
+// capabilities of the interpreter are rejected before evaluation.
+func (slang *Xlisp) EvalContext(ctx context.Context, v sabre.Value) (sabre.Value, error) {
+	if err := slang.checkInterop(v); err != nil {
+		return nil, err
+	}
+
+	scope := &evalScope{
+		xl:    slang,
+		state: newEvalState(ctx, slang.Limits()),
+	}
+
+	res, err := sabre.Eval(scope, v)
+	if err != nil {
+		return nil, addFrame("", err)
+	}
+	return res, nil
 }
 
 // ReadEval reads from the given reader and evaluates all the forms
-// obtained in Slang context.
+// obtained in Slang context. Evaluation errors are returned as TraceError
+// with positions of the forms in the source read from 'r'.
 func (slang *Xlisp) ReadEval(r io.Reader) (sabre.Value, error) {
-	sabreReader := sabre.NewReader(r)
-	sabreReader.SetMacro('!', readSheBang, true)
-	mod, err := sabreReader.All()
+	return slang.ReadEvalContext(context.Background(), r)
+}
+
+// ReadEvalContext reads all the forms from the given reader and evaluates
+// them using EvalContext.
+func (slang *Xlisp) ReadEvalContext(ctx context.Context, r io.Reader) (sabre.Value, error) {
+	mod, err := NewReader(r).All()
 	if err != nil {
 		return nil, err
 	}
-	return sabre.Eval(slang, mod)
+	return slang.EvalContext(ctx, mod)
 }
 
-// removes shebang line
-func readSheBang(rd *sabre.Reader, _ rune) (sabre.Value, error) {
+// SetLimits sets the limits applied to subsequent evaluations.
+func (slang *Xlisp) SetLimits(limits Limits) {
+	slang.mu.Lock()
+	defer slang.mu.Unlock()
+
+	slang.limits = limits
+}
+
+// Limits returns the limits applied to evaluations.
+func (slang *Xlisp) Limits() Limits {
+	slang.mu.RLock()
+	defer slang.mu.RUnlock()
+
+	return slang.limits
+}
+
+// Reader reads xlisp forms. It wraps a sabre reader with the xlisp
+// specific reader macros installed and reads the number literals sabre
+// does not know about, such as 1N, 1.5M and 1/3, since sabre reads
+// numbers before consulting its read table.
+type Reader struct {
+	*sabre.Reader
+}
+
+// NewReader returns a reader with the xlisp specific reader macros
+// installed. Vector, hash-map and set literals are read as the persistent
+// collections Vector, HashMap and Set.
+func NewReader(r io.Reader) *Reader {
+	rd := sabre.NewReader(r)
+	rd.SetMacro('^', readMeta, false)
+	rd.SetMacro(':', readKeyword, false)
+	rd.SetMacro('(', readList, false)
+	rd.SetMacro('[', readVector, false)
+	rd.SetMacro('{', readHashMap, false)
+	rd.SetMacro('{', readSet, true)
+	rd.SetMacro('"', readRegex, true)
+	rd.SetMacro('(', anonFnReader(), true)
+	rd.SetMacro('\'', quoteFormReader("var"), true)
+	rd.SetMacro('@', quoteFormReader("deref"), false)
+	rd.SetMacro('`', quoteFormReader("syntax-quote"), false)
+	rd.SetMacro('~', quoteFormReader("unquote"), false)
+
+	// quote is handled by readForm so that symbols such as +' can be read.
+	rd.SetMacro('\'', nil, false)
+	return &Reader{Reader: rd}
+}
+
+// One reads the next form from the stream ignoring comments.
+func (rd *Reader) One() (sabre.Value, error) {
+	return readForm(rd.Reader)
+}
+
+// All reads forms until EOF and returns them as a module.
+func (rd *Reader) All() (sabre.Value, error) {
+	var forms []sabre.Value
 	for {
+		form, err := rd.One()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+
+		forms = append(forms, form)
+	}
+
+	return sabre.Module(forms), nil
+}
+
+// readForm reads the next form like sabre.Reader.One, except for number
+// literals and quote forms which are read by xlisp. Reader macros reading
+// nested forms must use readForm for the nested forms to get the same
+// behavior.
+func readForm(rd *sabre.Reader) (sabre.Value, error) {
+	for {
+		if err := rd.SkipSpaces(); err != nil {
+			return nil, err
+		}
+
 		r, err := rd.NextRune()
 		if err != nil {
 			return nil, err
 		}
 
+		isComment, err := startsComment(rd, r)
+		if err != nil {
+			return nil, err
+		}
+
+		if isComment {
+			if err := skipLine(rd); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if r == '\'' {
+			form, err := quoteFormReader("quote")(rd, r)
+			if err != nil {
+				return nil, annotateErr(rd, err)
+			}
+			return form, nil
+		}
+
+		isNumber, err := startsNumber(rd, r)
+		if err != nil {
+			return nil, annotateErr(rd, err)
+		}
+
+		if isNumber {
+			num, err := readNumber(rd, r)
+			if err != nil {
+				return nil, annotateErr(rd, err)
+			}
+			return num, nil
+		}
+
+		rd.Unread(r)
+		return rd.One()
+	}
+}
+
+// startsComment returns true if the rune starts a comment or a shebang
+// line.
+func startsComment(rd *sabre.Reader, r rune) (bool, error) {
+	if r == ';' {
+		return true, nil
+	}
+
+	if r != '#' {
+		return false, nil
+	}
+
+	next, err := rd.NextRune()
+	if err != nil {
+		if err == io.EOF {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if next == '!' {
+		return true, nil
+	}
+
+	rd.Unread(next)
+	return false, nil
+}
+
+func annotateErr(rd *sabre.Reader, err error) error {
+	if err == io.EOF {
+		return err
+	}
+
+	return sabre.ReadError{Cause: err, Position: rd.Position()}
+}
+
+// quoteFormReader returns a reader macro reading the next form as
+// (expandFunc form).
+func quoteFormReader(expandFunc string) sabre.ReaderMacro {
+	return func(rd *sabre.Reader, _ rune) (sabre.Value, error) {
+		form, err := readForm(rd)
+		if err != nil {
+			if err == io.EOF {
+				return nil, fmt.Errorf("%w: while reading quote form", sabre.ErrEOF)
+			}
+			return nil, err
+		}
+
+		return &sabre.List{
+			Values: []sabre.Value{sabre.Symbol{Value: expandFunc}, form},
+		}, nil
+	}
+}
+
+// readList reads a list form.
+func readList(rd *sabre.Reader, _ rune) (sabre.Value, error) {
+	pos := rd.Position()
+
+	forms, err := readContainer(rd, ')', "list")
+	if err != nil {
+		return nil, err
+	}
+
+	return &sabre.List{Values: forms, Position: pos}, nil
+}
+
+// readVector reads a vector literal as a persistent Vector.
+func readVector(rd *sabre.Reader, _ rune) (sabre.Value, error) {
+	pos := rd.Position()
+
+	forms, err := readContainer(rd, ']', "vector")
+	if err != nil {
+		return nil, err
+	}
+
+	vec := NewVector(forms...)
+	vec.Position = pos
+	return vec, nil
+}
+
+// readSet reads a set literal as a persistent Set.
+func readSet(rd *sabre.Reader, _ rune) (sabre.Value, error) {
+	pos := rd.Position()
+
+	forms, err := readContainer(rd, '}', "set")
+	if err != nil {
+		return nil, err
+	}
+
+	set := NewSet(forms...)
+	if set.Size() != len(forms) {
+		return nil, fmt.Errorf("duplicate value in set")
+	}
+
+	set.Position = pos
+	return set, nil
+}
+
+// readHashMap reads a hash-map literal as a persistent HashMap. Unlike the
+// sabre reader, any form is accepted as a key so that map destructuring
+// patterns such as {a :a :or {a 1}} can be read.
+func readHashMap(rd *sabre.Reader, _ rune) (sabre.Value, error) {
+	pos := rd.Position()
+
+	forms, err := readContainer(rd, '}', "hash-map")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(forms)%2 != 0 {
+		return nil, fmt.Errorf("expecting even number of forms within {}")
+	}
+
+	hm := NewHashMap(forms...)
+	if 2*hm.Size() != len(forms) {
+		return nil, fmt.Errorf("duplicate key in hash-map")
+	}
+
+	hm.Position = pos
+	return hm, nil
+}
+
+// readContainer reads forms until the end rune. Unlike rd.One, comments
+// right before the end rune are allowed.
+func readContainer(rd *sabre.Reader, end rune, formType string) ([]sabre.Value, error) {
+	var forms []sabre.Value
+	for {
+		if err := rd.SkipSpaces(); err != nil {
+			return nil, containerErr(err, formType)
+		}
+
+		r, err := rd.NextRune()
+		if err != nil {
+			return nil, containerErr(err, formType)
+		}
+
+		if r == end {
+			return forms, nil
+		}
+
+		if r == ';' {
+			if err := skipLine(rd); err != nil {
+				return nil, containerErr(err, formType)
+			}
+			continue
+		}
+		rd.Unread(r)
+
+		form, err := readForm(rd)
+		if err != nil {
+			return nil, containerErr(err, formType)
+		}
+		forms = append(forms, form)
+	}
+}
+
+func skipLine(rd *sabre.Reader) error {
+	for {
+		r, err := rd.NextRune()
+		if err != nil {
+			return err
+		}
+
 		if r == '\n' {
-			break
+			return nil
 		}
 	}
+}
 
-	return nil, sabre.ErrSkip
+func containerErr(err error, formType string) error {
+	if err == io.EOF {
+		return fmt.Errorf("%w: while reading %s", sabre.ErrEOF, formType)
+	}
+	return err
 }
 
 // ReadEvalStr reads the source and evaluates it in Slang context.
@@ -78,12 +489,41 @@ func (slang *Xlisp) ReadEvalStr(src string) (sabre.Value, error) {
 }
 
 // Bind binds the given name to the given Value into the slang interpreter
-// context.
+// context. Attributes of an existing var, such as its visibility, are
+// retained. New vars named following the *earmuffs* convention are
+// dynamic, so that values such as *file* bound from Go can be rebound
+// using the binding form.
 func (slang *Xlisp) Bind(symbol string, v sabre.Value) error {
+	return slang.intern(symbol, v, func(vr *Var, found bool) {
+		if !found {
+			vr.Dynamic = isEarmuffed(vr.Name)
+		}
+	})
+}
+
+// Define binds the given name to the value like Bind but replaces the
+// attributes and the metadata of any existing var with the ones given in
+// the metadata, plus :ns.
+// Vars with :private metadata can only be resolved from their own
+// namespace and vars with :dynamic metadata can be rebound using the
+// binding form.
+func (slang *Xlisp) Define(symbol string, v sabre.Value, meta *HashMap) error {
+	return slang.intern(symbol, v, func(vr *Var, _ bool) {
+		vr.Private = metaFlag(meta, "private")
+		vr.Dynamic = metaFlag(meta, "dynamic")
+
+		vr.Meta = NewHashMap(Keyword("ns"), sabre.Symbol{Value: vr.NS})
+		if meta != nil {
+			vr.Meta = vr.Meta.Conj(meta).(*HashMap)
+		}
+	})
+}
+
+func (slang *Xlisp) intern(symbol string, v sabre.Value, setAttrs func(vr *Var, found bool)) error {
 	slang.mu.Lock()
 	defer slang.mu.Unlock()
 
-	nsSym, err := slang.splitSymbol(symbol)
+	nsSym, err := splitSymbol(slang.currentNS, symbol)
 	if err != nil {
 		return err
 	}
@@ -92,26 +532,31 @@ func (slang *Xlisp) Bind(symbol string, v sabre.Value) error {
 		return fmt.Errorf("cannot bind outside current namespace")
 	}
 
-	slang.bindings[*nsSym] = v
+	vr, found := slang.bindings[*nsSym]
+	if !found {
+		vr = &Var{NS: nsSym.NS, Name: nsSym.Name}
+		slang.bindings[*nsSym] = vr
+	}
+
+	vr.Value = v
+	setAttrs(vr, found)
 	return nil
 }
 
 // Resolve finds the value bound to the given symbol and returns it if
-// found in the Slang context and returns it.
+// found in the Slang context and returns it. Namespace aliases and symbols
+// referred into the current namespace through require are taken into
+// account.
 func (slang *Xlisp) Resolve(symbol string) (sabre.Value, error) {
 	slang.mu.RLock()
 	defer slang.mu.RUnlock()
 
-	if symbol == "ns" {
-		symbol = "user/ns"
-	}
-
-	nsSym, err := slang.splitSymbol(symbol)
+	syms, err := slang.candidates(slang.currentNS, symbol)
 	if err != nil {
 		return nil, err
 	}
 
-	return slang.resolveAny(symbol, *nsSym, nsSym.WithNS("core"))
+	return slang.resolveAny(slang.currentNS, symbol, syms...)
 }
 
 // BindGo is similar to Bind but handles conversion of Go value 'v' to
@@ -142,22 +587,81 @@ func (slang *Xlisp) Parent() sabre.Scope {
 	return nil
 }
 
-func (slang *Xlisp) resolveAny(symbol string, syms ...nsSymbol) (sabre.Value, error) {
+// resolveAny returns the value of the first var bound to one of the
+// symbols. Private vars are not visible outside their namespace.
+func (slang *Xlisp) resolveAny(ns, symbol string, syms ...nsSymbol) (sabre.Value, error) {
+	vr, err := slang.findVar(ns, symbol, syms...)
+	if err != nil {
+		return nil, err
+	}
+
+	return vr.Value, nil
+}
+
+// findVar returns the first var bound to one of the symbols.
+func (slang *Xlisp) findVar(ns, symbol string, syms ...nsSymbol) (*Var, error) {
 	for _, s := range syms {
-		v, found := slang.bindings[s]
-		if found {
-			return v, nil
+		vr, found := slang.bindings[s]
+		if !found {
+			continue
+		}
+
+		if vr.Private && vr.NS != ns {
+			return nil, fmt.Errorf("var: %s is not public", vr)
 		}
+
+		return vr, nil
 	}
 
 	return nil, fmt.Errorf("unable to resolve symbol: %v", symbol)
 }
 
-func (slang *Xlisp) splitSymbol(symbol string) (*nsSymbol, error) {
+// resolveVar returns the var the symbol refers to when resolved from
+// namespace 'ns' along with its root value.
+func (slang *Xlisp) resolveVar(ns, symbol string) (*Var, sabre.Value, error) {
+	slang.mu.RLock()
+	defer slang.mu.RUnlock()
+
+	syms, err := slang.candidates(ns, symbol)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	vr, err := slang.findVar(ns, symbol, syms...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return vr, vr.Value, nil
+}
+
+// candidates returns the qualified symbols the given symbol may refer to
+// when resolved from namespace 'ns' in the order they should be looked up.
+func (slang *Xlisp) candidates(ns, symbol string) ([]nsSymbol, error) {
+	nsSym, err := splitSymbol(ns, symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	if target, found := slang.aliases[ns][nsSym.NS]; found {
+		nsSym.NS = target
+	}
+
+	syms := []nsSymbol{*nsSym}
+	if target, found := slang.refers[*nsSym]; found {
+		syms = append(syms, target)
+	}
+
+	return append(syms, nsSym.WithNS("core")), nil
+}
+
+// splitSymbol splits a qualified symbol into its namespace and name parts.
+// Unqualified symbols are qualified with namespace 'ns'.
+func splitSymbol(ns, symbol string) (*nsSymbol, error) {
 	sep := string(nsSeparator)
 	if symbol == sep {
 		return &nsSymbol{
-			NS:   slang.currentNS,
+			NS:   ns,
 			Name: symbol,
 		}, nil
 	}
@@ -165,7 +669,7 @@ func (slang *Xlisp) splitSymbol(symbol string) (*nsSymbol, error) {
 	parts := strings.SplitN(symbol, sep, 2)
 	if len(parts) < 2 {
 		return &nsSymbol{
-			NS:   slang.currentNS,
+			NS:   ns,
 			Name: symbol,
 		}, nil
 	}
@@ -189,3 +693,19 @@ func (s nsSymbol) WithNS(ns string) nsSymbol {
 	s.NS = ns
 	return s
 }
+
+// Var is a value bound to a name in a namespace. The value of a dynamic
+// var can be rebound for the extent of a binding form. Vars created by def
+// hold the metadata given to def in Meta.
+type Var struct {
+	NS      string
+	Name    string
+	Value   sabre.Value
+	Meta    *HashMap
+	Private bool
+	Dynamic bool
+}
+
+func (vr *Var) String() string {
+	return fmt.Sprintf("#'%s/%s", vr.NS, vr.Name)
+}
@@ -1,11 +1,17 @@
 package xlisp_test
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"math/rand"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/issadarkthing/xlisp"
 	"github.com/spy16/sabre"
@@ -92,6 +98,1108 @@ func TestXlisp_Resolve(t *testing.T) {
 	}
 }
 
+func TestXlisp_ReadEvalTrace(t *testing.T) {
+	sl := xlisp.New()
+
+	src := `(def foo (fn* foo [x]
+  (bar x)))
+(def bar (fn* bar [y]
+  (undefined-thing y)))
+(foo 1)`
+
+	_, err := sl.ReadEvalStr(src)
+
+	var te *xlisp.TraceError
+	if !errors.As(err, &te) {
+		t.Fatalf("ReadEvalStr() error = %#v, want TraceError", err)
+	}
+
+	want := []struct {
+		name string
+		line int
+	}{
+		{name: "bar", line: 4},
+		{name: "foo", line: 2},
+		{name: "", line: 5},
+	}
+
+	if len(te.Frames) != len(want) {
+		t.Fatalf("got %d frames, want %d: %v", len(te.Frames), len(want), te)
+	}
+
+	for i, w := range want {
+		frame := te.Frames[i]
+		if frame.Name != w.name || frame.Line != w.line || frame.File != "<string>" {
+			t.Errorf("frame %d = %v, want %s at line %d", i, frame, w.name, w.line)
+		}
+	}
+}
+
+func TestXlisp_DestructureTrace(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		frames []xlisp.Frame
+	}{
+		{
+			name: "FnParams",
+			src: `(def foo (fn* foo [x [a b]]
+  a))
+(foo 1 2)`,
+			frames: []xlisp.Frame{
+				{Name: "foo", Position: sabre.Position{File: "<string>", Line: 1, Column: 22}},
+				{Position: sabre.Position{File: "<string>", Line: 3, Column: 1}},
+			},
+		},
+		{
+			name: "Let",
+			src: `(def bar (fn* bar [x]
+  (let [{:keys [y]} x]
+    y)))
+(bar 2)`,
+			frames: []xlisp.Frame{
+				{Name: "bar", Position: sabre.Position{File: "<string>", Line: 2, Column: 9}},
+				{Position: sabre.Position{File: "<string>", Line: 4, Column: 1}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := xlisp.New().ReadEvalStr(tt.src)
+
+			var te *xlisp.TraceError
+			if !errors.As(err, &te) {
+				t.Fatalf("ReadEvalStr() error = %#v, want TraceError", err)
+			}
+
+			if len(te.Frames) != len(tt.frames) {
+				t.Fatalf("got %d frames, want %d: %v", len(te.Frames), len(tt.frames), te)
+			}
+
+			for i, want := range tt.frames {
+				if te.Frames[i] != want {
+					t.Errorf("frame %d = %v, want %v", i, te.Frames[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestXlisp_Require(t *testing.T) {
+	os.Setenv("XLISP_PATH", "testdata")
+	defer os.Unsetenv("XLISP_PATH")
+
+	sl := xlisp.New()
+
+	tests := []struct {
+		name    string
+		src     string
+		want    sabre.Value
+		wantErr bool
+	}{
+		{
+			name: "AliasAndRefer",
+			src:  "(require '[util.text :as t :refer [shout]]) (shout t/greeting)",
+			want: sabre.String("hello!"),
+		},
+		{
+			name: "LoadedOnce",
+			src:  "(def cache t/cache) (require 'util.text '[util.text :as txt]) (= cache txt/cache)",
+			want: sabre.Bool(true),
+		},
+		{
+			name: "ReferAll",
+			src:  "(require '[util.text :refer :all]) greeting",
+			want: sabre.String("hello"),
+		},
+		{
+			name: "CurrentNSRestored",
+			src:  "*ns*",
+			want: sabre.Symbol{Value: "user"},
+		},
+		{
+			name:    "MissingNamespace",
+			src:     "(require 'util.missing)",
+			wantErr: true,
+		},
+		{
+			name:    "MissingReferredSymbol",
+			src:     "(require '[util.text :refer [whisper]])",
+			wantErr: true,
+		},
+		{
+			name:    "PrivateNotResolvable",
+			src:     "t/suffix",
+			wantErr: true,
+		},
+		{
+			name:    "PrivateNotReferable",
+			src:     "(require '[util.text :refer [suffix]])",
+			wantErr: true,
+		},
+		{
+			name:    "PrivateNotReferredByAll",
+			src:     "suffix",
+			wantErr: true,
+		},
+		{
+			name:    "CyclicDependency",
+			src:     "(require 'cycle.a)",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sl.ReadEvalStr(tt.src)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ReadEvalStr() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			if !tt.wantErr && !sabre.Compare(got, tt.want) {
+				t.Errorf("ReadEvalStr() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestXlisp_Namespaces(t *testing.T) {
+	sl := xlisp.New()
+
+	src := "(ns 'scratch) (def a 1) (def b 2) (def ^:private hidden 3) (ns 'user)"
+	if _, err := sl.ReadEvalStr(src); err != nil {
+		t.Fatalf("failed to setup: %v", err)
+	}
+
+	publics := sl.Publics("scratch")
+	if len(publics) != 3 || !sabre.Compare(publics["a"], sabre.Int64(1)) {
+		t.Errorf("Publics() = %v, want a, b and *ns*", publics)
+	}
+
+	interns := sl.Interns("scratch")
+	if len(interns) != 4 || !sabre.Compare(interns["hidden"], sabre.Int64(3)) {
+		t.Errorf("Interns() = %v, want a, b, hidden and *ns*", interns)
+	}
+
+	if _, err := sl.ResolveIn("scratch", "hidden"); err != nil {
+		t.Errorf("ResolveIn() unexpected error: %v", err)
+	}
+
+	_, err := sl.Resolve("scratch/hidden")
+	if err == nil || !strings.Contains(err.Error(), "is not public") {
+		t.Errorf("Resolve() error = %v, want 'is not public'", err)
+	}
+
+	tests := []struct {
+		name string
+		src  string
+		want sabre.Value
+	}{
+		{
+			name: "NSName",
+			src:  `(ns-name "scratch")`,
+			want: sabre.Symbol{Value: "scratch"},
+		},
+		{
+			name: "NSResolve",
+			src:  "(ns-resolve 'scratch 'b)",
+			want: sabre.Int64(2),
+		},
+		{
+			name: "NSResolveMissing",
+			src:  "(ns-resolve 'scratch 'c)",
+			want: sabre.Nil{},
+		},
+		{
+			name: "NSUnmap",
+			src:  "(ns-unmap 'scratch 'b) (ns-resolve 'scratch 'b)",
+			want: sabre.Nil{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sl.ReadEvalStr(tt.src)
+			if err != nil {
+				t.Errorf("ReadEvalStr() error = %v", err)
+				return
+			}
+
+			if !sabre.Compare(got, tt.want) {
+				t.Errorf("ReadEvalStr() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if !hasNS(t, sl, "scratch") {
+		t.Errorf("all-ns does not contain scratch")
+	}
+
+	if _, err := sl.ReadEvalStr("(remove-ns 'scratch)"); err != nil {
+		t.Fatalf("remove-ns failed: %v", err)
+	}
+
+	if hasNS(t, sl, "scratch") {
+		t.Errorf("all-ns contains scratch after remove-ns")
+	}
+}
+
+func TestXlisp_EvalContext(t *testing.T) {
+	const infiniteLoop = "(loop [i 0] (recur (+ i 1)))"
+
+	tests := []struct {
+		name      string
+		src       string
+		limits    xlisp.Limits
+		timeout   time.Duration
+		wantLimit string
+		wantErr   error
+	}{
+		{
+			name:    "Cancelled",
+			src:     infiniteLoop,
+			timeout: 20 * time.Millisecond,
+			wantErr: context.DeadlineExceeded,
+		},
+		{
+			name:    "CancelledFuture",
+			src:     "(deref* (future* " + infiniteLoop + "))",
+			timeout: 20 * time.Millisecond,
+			wantErr: context.DeadlineExceeded,
+		},
+		{
+			name:    "CancelledLazy",
+			src:     "(doseq [x (map inc (range))] x)",
+			timeout: 20 * time.Millisecond,
+			wantErr: context.DeadlineExceeded,
+		},
+		{
+			name:    "CancelledSleep",
+			src:     "(sleep 60000)",
+			timeout: 20 * time.Millisecond,
+			wantErr: context.DeadlineExceeded,
+		},
+		{
+			name:    "CancelledSwap",
+			src:     "(swap! (atom " + infiniteLoop + ") inc)",
+			timeout: 20 * time.Millisecond,
+			wantErr: context.DeadlineExceeded,
+		},
+		{
+			name:      "MaxSteps",
+			src:       infiniteLoop,
+			limits:    xlisp.Limits{MaxSteps: 100},
+			wantLimit: "steps",
+		},
+		{
+			name:      "MaxDepth",
+			src:       "(def f (fn* f [n] (f (+ n 1)))) (f 0)",
+			limits:    xlisp.Limits{MaxDepth: 50},
+			wantLimit: "depth",
+		},
+		{
+			name:      "Timeout",
+			src:       infiniteLoop,
+			limits:    xlisp.Limits{Timeout: 20 * time.Millisecond},
+			wantLimit: "timeout",
+		},
+		{
+			name:      "SwapFn",
+			src:       "(swap! (atom 0) (fn* [_] " + infiniteLoop + "))",
+			limits:    xlisp.Limits{MaxSteps: 100},
+			wantLimit: "steps",
+		},
+		{
+			name:      "SwapAtom",
+			src:       "(swap! " + infiniteLoop + " inc)",
+			limits:    xlisp.Limits{MaxSteps: 100},
+			wantLimit: "steps",
+		},
+		{
+			name:      "NotCaught",
+			src:       "(try " + infiniteLoop + " (catch e :caught))",
+			limits:    xlisp.Limits{MaxSteps: 100},
+			wantLimit: "steps",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sl := xlisp.New()
+			sl.SetLimits(tt.limits)
+
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+
+			_, err := sl.ReadEvalContext(ctx, strings.NewReader(tt.src))
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("ReadEvalContext() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if tt.wantLimit != "" {
+				var limitErr *xlisp.LimitError
+				if !errors.As(err, &limitErr) || limitErr.Limit != tt.wantLimit {
+					t.Errorf("ReadEvalContext() error = %v, want %s limit error",
+						err, tt.wantLimit)
+				}
+			}
+		})
+	}
+}
+
+func TestXlisp_LazySeqDepth(t *testing.T) {
+	sl := xlisp.New()
+	sl.SetLimits(xlisp.Limits{MaxDepth: 50})
+
+	got, err := sl.ReadEvalStr("(count (filter odd? (map inc (range 500))))")
+	if err != nil {
+		t.Fatalf("ReadEvalStr() unexpected error: %v", err)
+	}
+
+	if !sabre.Compare(got, sabre.Int64(250)) {
+		t.Errorf("ReadEvalStr() = %v, want 250", got)
+	}
+}
+
+func TestXlisp_Sandbox(t *testing.T) {
+	os.Setenv("XLISP_PATH", "testdata")
+	defer os.Unsetenv("XLISP_PATH")
+
+	tests := []struct {
+		name    string
+		caps    xlisp.Capability
+		src     string
+		wantCap xlisp.Capability
+	}{
+		{
+			name:    "Shell",
+			caps:    xlisp.CapAll &^ xlisp.CapShell,
+			src:     `($ "echo hello")`,
+			wantCap: xlisp.CapShell,
+		},
+		{
+			name:    "ReadFile",
+			caps:    xlisp.CapAll &^ xlisp.CapFilesystem,
+			src:     `(read-file "go.mod")`,
+			wantCap: xlisp.CapFilesystem,
+		},
+		{
+			name:    "LoadFile",
+			caps:    xlisp.CapAll &^ xlisp.CapFilesystem,
+			src:     `(load-file "testdata/util/text.xlisp")`,
+			wantCap: xlisp.CapFilesystem,
+		},
+		{
+			name:    "Require",
+			caps:    xlisp.CapAll &^ xlisp.CapFilesystem,
+			src:     `(require 'util.text)`,
+			wantCap: xlisp.CapFilesystem,
+		},
+		{
+			name:    "Stdin",
+			caps:    xlisp.CapAll &^ xlisp.CapProcess,
+			src:     `(read* "")`,
+			wantCap: xlisp.CapProcess,
+		},
+		{
+			name:    "Terminal",
+			caps:    xlisp.CapAll &^ xlisp.CapProcess,
+			src:     `(tview/new-app)`,
+			wantCap: xlisp.CapProcess,
+		},
+		{
+			name:    "Member",
+			caps:    xlisp.CapAll &^ xlisp.CapInterop,
+			src:     `(def t (type 1)) (t.T.Name)`,
+			wantCap: xlisp.CapInterop,
+		},
+		{
+			name:    "MemberInEval",
+			caps:    xlisp.CapAll &^ xlisp.CapInterop,
+			src:     `(def t (type 1)) (eval-string "(t.T.Name)")`,
+			wantCap: xlisp.CapInterop,
+		},
+		{
+			name: "MemberInMacro",
+			caps: xlisp.CapAll &^ xlisp.CapInterop,
+			src: `(def a (atom 1))
+			      (defmacro m [] (list (ns-name "a.UpdateState") '(fn [_] 42)))
+			      (m)`,
+			wantCap: xlisp.CapInterop,
+		},
+		{
+			name: "AllowedMember",
+			caps: xlisp.CapAll &^ xlisp.CapInterop,
+			src:  `(def l '(1 2)) (l.First)`,
+		},
+		{
+			name: "Granted",
+			caps: xlisp.CapAll,
+			src:  `(def t (type 1)) (t.T.Name)`,
+		},
+		{
+			name: "InStr",
+			caps: xlisp.CapAll &^ xlisp.CapProcess,
+			src:  `(with-in-str "line" (read* ""))`,
+		},
+		{
+			name:    "Source",
+			caps:    xlisp.CapAll &^ xlisp.CapFilesystem,
+			src:     `(defn f [] 1) (source f)`,
+			wantCap: xlisp.CapFilesystem,
+		},
+		{
+			name: "CoreSource",
+			caps: xlisp.CapAll &^ xlisp.CapFilesystem,
+			src:  `(with-out-str (source inc))`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sl := xlisp.New(xlisp.WithCapabilities(tt.caps))
+
+			_, err := sl.ReadEvalStr(tt.src)
+			if tt.wantCap == 0 {
+				if err != nil {
+					t.Errorf("ReadEvalStr() unexpected error: %v", err)
+				}
+				return
+			}
+
+			var capErr *xlisp.CapabilityError
+			if !errors.As(err, &capErr) || capErr.Capability != tt.wantCap {
+				t.Errorf("ReadEvalStr() error = %v, want %s capability error",
+					err, tt.wantCap)
+			}
+		})
+	}
+}
+
+func TestXlisp_Docs(t *testing.T) {
+	sl := xlisp.New()
+
+	for _, ns := range []string{"core", "math", "string", "types", "unsafe", "tview"} {
+		for name := range sl.Publics(ns) {
+			if name == "*ns*" {
+				continue
+			}
+
+			sym := ns + "/" + name
+			meta, err := sl.ReadEvalStr("(meta (var " + sym + "))")
+			if err != nil {
+				t.Errorf("meta of %s failed: %v", sym, err)
+				continue
+			}
+
+			hm, ok := meta.(*xlisp.HashMap)
+			if !ok {
+				t.Errorf("%s has no metadata", sym)
+				continue
+			}
+
+			_, hasDoc := hm.Lookup(xlisp.Keyword("doc"))
+			_, hasFile := hm.Lookup(xlisp.Keyword("file"))
+			if !hasDoc && !hasFile {
+				t.Errorf("%s has neither :doc nor :file metadata", sym)
+			}
+		}
+	}
+}
+
+func TestXlisp_InteropAllowList(t *testing.T) {
+	sl := xlisp.New(
+		xlisp.WithCapabilities(0),
+		xlisp.WithInteropAllowList("T", "Name"),
+	)
+
+	got, err := sl.ReadEvalStr(`(def t (type 1)) (t.T.Name)`)
+	if err != nil {
+		t.Fatalf("ReadEvalStr() unexpected error: %v", err)
+	}
+
+	if !sabre.Compare(got, sabre.String("Int64")) {
+		t.Errorf("ReadEvalStr() = %v, want Int64", got)
+	}
+}
+
+func TestNew_Options(t *testing.T) {
+	var out bytes.Buffer
+	sl := xlisp.New(
+		xlisp.WithStdin(strings.NewReader("world\nagain\n")),
+		xlisp.WithStdout(&out),
+		xlisp.WithNamespace("app"),
+		xlisp.WithBindings(map[string]interface{}{
+			"answer": 42,
+			"lib/pi": 3.14,
+		}),
+		xlisp.WithCoreLibrary(strings.NewReader(`(ns 'lib) (def greeting "hello")`)),
+	)
+
+	tests := []struct {
+		name string
+		src  string
+		want sabre.Value
+	}{
+		{
+			name: "Namespace",
+			src:  "*ns*",
+			want: sabre.Symbol{Value: "app"},
+		},
+		{
+			name: "Bindings",
+			src:  "[answer lib/pi]",
+			want: sabre.Vector{Values: []sabre.Value{sabre.Int64(42), sabre.Float64(3.14)}},
+		},
+		{
+			name: "CoreLibrary",
+			src:  "lib/greeting",
+			want: sabre.String("hello"),
+		},
+		{
+			name: "Stdin",
+			src:  `(read* "name? ")`,
+			want: sabre.String("world"),
+		},
+		{
+			name: "StdinBuffered",
+			src:  `(read* "")`,
+			want: sabre.String("again"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sl.ReadEvalStr(tt.src)
+			if err != nil {
+				t.Fatalf("ReadEvalStr() unexpected error: %v", err)
+			}
+
+			if !sabre.Compare(got, tt.want) {
+				t.Errorf("ReadEvalStr() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	out.Reset()
+	if _, err := sl.ReadEvalStr(`(print "a" 1) (printf "%d-%d" 2 3)`); err != nil {
+		t.Fatalf("ReadEvalStr() unexpected error: %v", err)
+	}
+
+	if want := "\"a\" 1\n2-3"; out.String() != want {
+		t.Errorf("stdout = %q, want %q", out.String(), want)
+	}
+}
+
+func TestXlisp_Streams(t *testing.T) {
+	var out, errOut bytes.Buffer
+	sl := xlisp.New(xlisp.WithStdout(&out), xlisp.WithStderr(&errOut))
+
+	got, err := sl.ReadEvalStr(`(print 1) (with-out-str (print 2))`)
+	if err != nil {
+		t.Fatalf("ReadEvalStr() unexpected error: %v", err)
+	}
+
+	if !sabre.Compare(got, sabre.String("2\n")) {
+		t.Errorf("ReadEvalStr() = %v, want \"2\\n\"", got)
+	}
+
+	if _, err := sl.ReadEvalStr(`(let [*out* *err*] (print 3))`); err != nil {
+		t.Fatalf("ReadEvalStr() unexpected error: %v", err)
+	}
+
+	var w bytes.Buffer
+	if err := sl.BindGo("w", &w); err != nil {
+		t.Fatalf("BindGo() unexpected error: %v", err)
+	}
+
+	if _, err := sl.ReadEvalStr(`(let [*out* w] (print 4))`); err != nil {
+		t.Fatalf("ReadEvalStr() unexpected error: %v", err)
+	}
+
+	if _, err := sl.ReadEvalStr(`(eprint 6) (eprintf "%d\n" 7) (let [*err* w] (eprint 8))`); err != nil {
+		t.Fatalf("ReadEvalStr() unexpected error: %v", err)
+	}
+
+	if _, err := sl.ReadEvalStr(`(let [*out* 5] (print 5))`); err == nil {
+		t.Errorf("ReadEvalStr() expected error for invalid *out*")
+	}
+
+	if _, err := sl.ReadEvalStr(`(let [*err* 5] (eprint 5))`); err == nil {
+		t.Errorf("ReadEvalStr() expected error for invalid *err*")
+	}
+
+	for _, tt := range []struct {
+		name string
+		buf  *bytes.Buffer
+		want string
+	}{
+		{name: "*out*", buf: &out, want: "1\n"},
+		{name: "*err*", buf: &errOut, want: "3\n6\n7\n"},
+		{name: "Writer", buf: &w, want: "4\n8\n"},
+	} {
+		if got := tt.buf.String(); got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestXlisp_Binding(t *testing.T) {
+	sl := xlisp.New()
+	if err := sl.BindGo("*file*", "main.xlisp"); err != nil {
+		t.Fatalf("BindGo() unexpected error: %v", err)
+	}
+
+	got, err := sl.ReadEvalStr(`(binding [*file* "other.xlisp"] *file*)`)
+	if err != nil {
+		t.Fatalf("ReadEvalStr() unexpected error: %v", err)
+	}
+
+	if !sabre.Compare(got, sabre.String("other.xlisp")) {
+		t.Errorf("ReadEvalStr() = %v, want \"other.xlisp\"", got)
+	}
+
+	if v, _ := sl.Resolve("*file*"); !sabre.Compare(v, sabre.String("main.xlisp")) {
+		t.Errorf("Resolve() = %v, want \"main.xlisp\"", v)
+	}
+
+	if _, err := sl.ReadEvalStr(`(def ^:dynamic *v* 0) (defn v [] *v*)`); err != nil {
+		t.Fatalf("ReadEvalStr() unexpected error: %v", err)
+	}
+
+	results := make(chan error, 4)
+	for i := 1; i <= cap(results); i++ {
+		go func(i int) {
+			src := fmt.Sprintf("(binding [*v* %d] (sleep 10) (v))", i)
+			got, err := sl.ReadEvalStr(src)
+			if err == nil && !sabre.Compare(got, sabre.Int64(i)) {
+				err = fmt.Errorf("ReadEvalStr(%q) = %v, want %d", src, got, i)
+			}
+			results <- err
+		}(i)
+	}
+
+	for i := 0; i < cap(results); i++ {
+		if err := <-results; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestNew_CoreLibrary(t *testing.T) {
+	src := "(defn twice [x] (* 2 x)) (map twice [1 2])"
+
+	got, err := xlisp.New().ReadEvalStr(src)
+	if err != nil {
+		t.Fatalf("ReadEvalStr() unexpected error: %v", err)
+	}
+
+	want := &sabre.List{Values: []sabre.Value{sabre.Int64(2), sabre.Int64(4)}}
+	if !sabre.Compare(got, want) {
+		t.Errorf("ReadEvalStr() = %v, want %v", got, want)
+	}
+
+	if _, err := xlisp.New(xlisp.WithoutCoreLibrary()).ReadEvalStr(src); err == nil {
+		t.Errorf("ReadEvalStr() expected error without core library")
+	}
+}
+
+func TestReader_Numbers(t *testing.T) {
+	table := []struct {
+		src     string
+		want    string
+		wantErr bool
+	}{
+		{src: "42", want: "42"},
+		{src: "-0x10", want: "-16"},
+		{src: "2r101", want: "5"},
+		{src: "1.5", want: "1.500000"},
+		{src: "1e3", want: "1000.000000"},
+		{src: "12N", want: "12N"},
+		{src: "-12N", want: "-12N"},
+		{src: "92233720368547758070", want: "92233720368547758070N"},
+		{src: "1.50M", want: "1.50M"},
+		{src: "-0.05M", want: "-0.05M"},
+		{src: "15e2M", want: "1500M"},
+		{src: "2/6", want: "1/3"},
+		{src: "-4/2", want: "-2"},
+		{src: "[1/2 3N]", want: "[1/2 3N]"},
+		{src: "'1N", want: "(quote 1N)"},
+		{src: "+'", want: "+'"},
+		{src: "1/0", wantErr: true},
+		{src: "1/x", wantErr: true},
+		{src: "1.5N", wantErr: true},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.src, func(t *testing.T) {
+			form, err := xlisp.NewReader(strings.NewReader(tt.src)).One()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("One() error = %v, wantErr %t", err, tt.wantErr)
+			}
+
+			if err == nil && fmt.Sprint(form) != tt.want {
+				t.Errorf("One() = %v, want %s", form, tt.want)
+			}
+		})
+	}
+}
+
+func TestReader_Regex(t *testing.T) {
+	table := []struct {
+		src     string
+		want    string
+		wantErr bool
+	}{
+		{src: `#"\d+"`, want: `\d+`},
+		{src: `#"a\"b"`, want: `a\"b`},
+		{src: `[#"x" 1]`, want: `[#"x" 1]`},
+		{src: `#"("`, wantErr: true},
+		{src: `#"abc`, wantErr: true},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.src, func(t *testing.T) {
+			form, err := xlisp.NewReader(strings.NewReader(tt.src)).One()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("One() error = %v, wantErr %t", err, tt.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			got := fmt.Sprint(form)
+			if re, ok := form.(*xlisp.Regex); ok {
+				got = re.Regexp.String()
+			}
+
+			if got != tt.want {
+				t.Errorf("One() = %v, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReader_Shorthands(t *testing.T) {
+	table := []struct {
+		src     string
+		want    string
+		wantErr bool
+	}{
+		{src: "#(inc %)", want: "(fn* [%1] (inc %1))"},
+		{src: "#(list %2 %&)", want: "(fn* [%1 %2 & %&] (list %2 %&))"},
+		{src: "#(f)", want: "(fn* [] (f))"},
+		{src: "@a", want: "(deref a)"},
+		{src: "#'a", want: "(var a)"},
+		{src: "#(%x)", wantErr: true},
+		{src: "#(f", wantErr: true},
+		{src: "#(+ % #(inc %))", wantErr: true},
+		{src: "#(map (fn* [x] x) %)", want: "(fn* [%1] (map (fn* [x] x) %1))"},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.src, func(t *testing.T) {
+			form, err := xlisp.NewReader(strings.NewReader(tt.src)).One()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("One() error = %v, wantErr %t", err, tt.wantErr)
+			}
+
+			if err == nil && fmt.Sprint(form) != tt.want {
+				t.Errorf("One() = %v, want %s", form, tt.want)
+			}
+		})
+	}
+}
+
+func TestVector(t *testing.T) {
+	const n = 5000
+
+	vec := xlisp.NewVector()
+	versions := []*xlisp.Vector{vec}
+	for i := 0; i < n; i++ {
+		vec = vec.Conj(sabre.Int64(i)).(*xlisp.Vector)
+		if i%1000 == 0 {
+			versions = append(versions, vec)
+		}
+	}
+
+	if vec.Size() != n {
+		t.Fatalf("Size() = %d, want %d", vec.Size(), n)
+	}
+
+	for i := 0; i < n; i++ {
+		if got := vec.Nth(i); got != sabre.Int64(i) {
+			t.Fatalf("Nth(%d) = %v, want %d", i, got, i)
+		}
+	}
+
+	for i, v := range versions {
+		want := 0
+		if i > 0 {
+			want = (i-1)*1000 + 1
+		}
+
+		if v.Size() != want {
+			t.Errorf("Size() of version %d = %d, want %d", i, v.Size(), want)
+		}
+	}
+
+	updated, err := vec.Assoc(1234, xlisp.Keyword("x"))
+	if err != nil {
+		t.Fatalf("Assoc() unexpected error: %v", err)
+	}
+
+	if got := updated.Nth(1234); got != xlisp.Keyword("x") {
+		t.Errorf("Nth(1234) = %v, want :x", got)
+	}
+
+	if got := vec.Nth(1234); got != sabre.Int64(1234) {
+		t.Errorf("Assoc() modified the original vector: Nth(1234) = %v", got)
+	}
+
+	if _, err := vec.Assoc(n+1, sabre.Nil{}); err == nil {
+		t.Errorf("Assoc() expected error for index out of bounds")
+	}
+}
+
+// collider is a value whose hash is the same for all instances.
+type collider struct{ n int }
+
+func (c collider) Eval(_ sabre.Scope) (sabre.Value, error) { return c, nil }
+func (c collider) String() string                          { return fmt.Sprintf("#collider[%d]", c.n) }
+func (c collider) Hash() uint32                            { return 42 }
+
+func TestHashMap(t *testing.T) {
+	const n = 5000
+
+	key := func(i int) sabre.Value {
+		switch i % 3 {
+		case 0:
+			return sabre.Int64(i)
+		case 1:
+			return xlisp.Keyword(fmt.Sprint("k", i))
+		default:
+			return collider{n: i}
+		}
+	}
+
+	hm := xlisp.NewHashMap()
+	for i := 0; i < n; i++ {
+		hm = hm.Assoc(key(i), sabre.Int64(i))
+	}
+	hm = hm.Assoc(key(42), sabre.Int64(-42))
+
+	if hm.Size() != n {
+		t.Fatalf("Size() = %d, want %d", hm.Size(), n)
+	}
+
+	for i := 0; i < n; i++ {
+		want := sabre.Value(sabre.Int64(i))
+		if i == 42 {
+			want = sabre.Int64(-42)
+		}
+
+		if got := hm.Get(key(i), sabre.Nil{}); got != want {
+			t.Fatalf("Get(%v) = %v, want %v", key(i), got, want)
+		}
+	}
+
+	removed := hm
+	for i := 0; i < n; i += 2 {
+		removed = removed.Dissoc(key(i))
+	}
+
+	if removed.Size() != n/2 {
+		t.Errorf("Size() after Dissoc = %d, want %d", removed.Size(), n/2)
+	}
+
+	for i := 0; i < n; i++ {
+		if got, want := removed.Contains(key(i)), i%2 == 1; got != want {
+			t.Fatalf("Contains(%v) = %t, want %t", key(i), got, want)
+		}
+
+		if !hm.Contains(key(i)) {
+			t.Fatalf("Dissoc() modified the original map: missing %v", key(i))
+		}
+	}
+
+	if !sabre.Compare(xlisp.NewHashMap(sabre.Symbol{Value: "a"}, sabre.Int64(1)),
+		xlisp.NewHashMap(sabre.Symbol{Value: "a", Position: sabre.Position{Line: 2}}, sabre.Int64(1))) {
+		t.Errorf("Compare() = false for maps with equal symbol keys")
+	}
+}
+
+func TestTransient(t *testing.T) {
+	const n = 3000
+
+	vec := xlisp.NewVector(sabre.Int64(-1))
+	tv := vec.Transient()
+	for i := 0; i < n; i++ {
+		if err := tv.Conj(sabre.Int64(i)); err != nil {
+			t.Fatalf("Conj() unexpected error: %v", err)
+		}
+	}
+	if err := tv.Assoc(0, sabre.Int64(100)); err != nil {
+		t.Fatalf("Assoc() unexpected error: %v", err)
+	}
+
+	grown, err := tv.Persistent()
+	if err != nil {
+		t.Fatalf("Persistent() unexpected error: %v", err)
+	}
+
+	if grown.Size() != n+1 || grown.Nth(0) != sabre.Int64(100) ||
+		grown.Nth(n) != sabre.Int64(n-1) {
+		t.Errorf("Persistent() = vector of size %d, want %d", grown.Size(), n+1)
+	}
+
+	if vec.Size() != 1 || vec.Nth(0) != sabre.Int64(-1) {
+		t.Errorf("transient modified the original vector: %v", vec)
+	}
+
+	// a second transient of the result must not modify it either.
+	tv = grown.Transient()
+	for i := 0; i < n; i += 7 {
+		_ = tv.Assoc(i, sabre.Nil{})
+	}
+	for i := 0; i < n; i += 7 {
+		if want := sabre.Value(sabre.Int64(i - 1)); i > 0 && grown.Nth(i) != want {
+			t.Fatalf("transient modified the original vector at %d", i)
+		}
+	}
+
+	if err := tv.Conj(sabre.Int64(0)); err != nil {
+		t.Fatalf("Conj() unexpected error: %v", err)
+	}
+	if _, err := tv.Persistent(); err != nil {
+		t.Fatalf("Persistent() unexpected error: %v", err)
+	}
+	if err := tv.Conj(sabre.Int64(0)); err == nil {
+		t.Errorf("Conj() after Persistent() expected error, got nil")
+	}
+
+	hm := xlisp.NewHashMap()
+	for i := 0; i < n; i++ {
+		hm = hm.Assoc(collider{n: i}, sabre.Int64(i))
+	}
+
+	tm := hm.Transient()
+	for i := 0; i < n; i += 2 {
+		_ = tm.Dissoc(collider{n: i})
+		_ = tm.Assoc(sabre.Int64(i), sabre.Int64(i))
+	}
+
+	res, err := tm.Persistent()
+	if err != nil {
+		t.Fatalf("Persistent() unexpected error: %v", err)
+	}
+
+	if res.Size() != n || hm.Size() != n {
+		t.Errorf("Size() = %d and %d, want %d", res.Size(), hm.Size(), n)
+	}
+
+	for i := 0; i < n; i++ {
+		if got, want := res.Contains(collider{n: i}), i%2 == 1; got != want {
+			t.Fatalf("Contains(%d) = %t, want %t", i, got, want)
+		}
+
+		if !hm.Contains(collider{n: i}) {
+			t.Fatalf("transient modified the original map: missing %d", i)
+		}
+	}
+}
+
+func BenchmarkVector_Conj(b *testing.B) {
+	for _, n := range []int{1000, 10000, 100000} {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			start := time.Now()
+			for i := 0; i < b.N; i++ {
+				var vec sabre.Seq = xlisp.NewVector()
+				for j := 0; j < n; j++ {
+					vec = vec.Conj(sabre.Int64(j))
+				}
+			}
+			reportPerElement(b, start, n)
+		})
+	}
+}
+
+func BenchmarkHashMap_Assoc(b *testing.B) {
+	for _, n := range []int{1000, 10000, 100000} {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			start := time.Now()
+			for i := 0; i < b.N; i++ {
+				hm := xlisp.NewHashMap()
+				for j := 0; j < n; j++ {
+					hm = hm.Assoc(sabre.Int64(j), sabre.Int64(j))
+				}
+			}
+			reportPerElement(b, start, n)
+		})
+	}
+}
+
+func BenchmarkXlisp_Conj(b *testing.B) {
+	sl := xlisp.New()
+	for _, n := range []int{1000, 10000, 100000} {
+		src := fmt.Sprintf("(count (reduce conj [] (range %d)))", n)
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			start := time.Now()
+			for i := 0; i < b.N; i++ {
+				if _, err := sl.ReadEvalStr(src); err != nil {
+					b.Fatalf("ReadEvalStr() unexpected error: %v", err)
+				}
+			}
+			reportPerElement(b, start, n)
+		})
+	}
+}
+
+// reportPerElement reports the time taken per element, which stays about
+// the same across collection sizes if building a collection takes linear
+// time.
+func reportPerElement(b *testing.B, start time.Time, n int) {
+	elapsed := time.Since(start)
+	b.ReportMetric(float64(elapsed.Nanoseconds())/float64(b.N*n), "ns/elem")
+}
+
+func TestNew_WithRandom(t *testing.T) {
+	src := "[(random 1000000) (shuffle [1 2 3 4 5 6 7 8 9])]"
+
+	var results []sabre.Value
+	for i := 0; i < 2; i++ {
+		sl := xlisp.New(xlisp.WithRandom(rand.New(rand.NewSource(42))))
+		v, err := sl.ReadEvalStr(src)
+		if err != nil {
+			t.Fatalf("ReadEvalStr() unexpected error: %v", err)
+		}
+		results = append(results, v)
+	}
+
+	if results[0].String() != results[1].String() {
+		t.Errorf("results differ with same source: %v and %v",
+			results[0], results[1])
+	}
+}
+
+func hasNS(t *testing.T, sl *xlisp.Xlisp, ns string) bool {
+	all, err := sl.ReadEvalStr("(all-ns)")
+	if err != nil {
+		t.Fatalf("all-ns failed: %v", err)
+	}
+
+	for _, v := range xlisp.Realize(all.(sabre.Seq)).Values {
+		if sabre.Compare(v, sabre.Symbol{Value: ns}) {
+			return true
+		}
+	}
+
+	return false
+}
+
 func TestXlisp(t *testing.T) {
 	if testing.Short() {
 		return
@@ -105,7 +1213,7 @@ func TestXlisp(t *testing.T) {
 	}
 
 	for _, fi := range files {
-		if !strings.HasSuffix(fi.Name(), "_test.lisp") {
+		if !strings.HasSuffix(fi.Name(), "_test.xlisp") {
 			continue
 		}
 
@@ -139,10 +1247,12 @@ func initxlisp() (*xlisp.Xlisp, error) {
 		return nil, err
 	}
 
+	// core.xlisp is embedded and loaded by New.
 	sl := xlisp.New()
 	for _, fi := range di {
-		if !strings.HasSuffix(fi.Name(), ".lisp") ||
-			strings.HasSuffix(fi.Name(), "_test.lisp") {
+		if !strings.HasSuffix(fi.Name(), ".xlisp") ||
+			strings.HasSuffix(fi.Name(), "_test.xlisp") ||
+			fi.Name() == "core.xlisp" {
 			continue
 		}
 